@@ -11,14 +11,22 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+const configWatchInterval = 2 * time.Second
+
 func main() {
 	logging.InitLog()
 
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+
 	flagConfig := flag.String("c", "config.yml", "Path to the config yaml file")
 	flagShowHelp := flag.Bool("h", false, "Show help and exit")
 	flagShowVersion := flag.Bool("v", false, "Show version and exit")
+	flagWatchConfig := flag.Bool("w", false, "Watch the config file, and reload the config when it's modified")
 	flag.Parse()
 
 	if *flagShowHelp {
@@ -30,18 +38,107 @@ func main() {
 		return
 	}
 
-	cfg := config.LoadConfigOrDie(*flagConfig)
+	configSource := config.NewConfigSource(*flagConfig)
+	cfg := config.LoadConfigOrDie(configSource)
 	cfg.Dump()
 
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(ch, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	log.Infof("SMCR v%s starting", constants.Version)
 	r := router.NewMinecraftRouter(cfg)
 	go r.Run()
 
-	sig := <-ch
-	log.Infof("Terminating by signal %s", sig)
-	r.Stop()
-	log.Infof("SMCR stopped")
+	reloadCh := make(chan struct{}, 1)
+	if *flagWatchConfig && !configSource.IsFile() {
+		log.Warnf("Config is loaded from %s, the config file is not watched", configSource)
+	} else if *flagWatchConfig {
+		log.Infof("Watching config file %s", *flagConfig)
+		go config.WatchConfigFile(*flagConfig, configWatchInterval, func() {
+			select {
+			case reloadCh <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	reloadConfig := func(reason string) {
+		log.Infof("Reloading config, reason: %s", reason)
+		logLevel := log.GetLevel()
+		newCfg, warnings, err := configSource.Load()
+		for _, warning := range warnings {
+			log.Warnf("Config warning: %s", warning)
+		}
+		if err != nil {
+			log.SetLevel(logLevel)
+			log.Errorf("Failed to reload config, keep using the current config: %v", err)
+			return
+		}
+		newCfg.Dump()
+		r.SetConfig(newCfg)
+		logging.SetFormat(newCfg.LogFormat)
+	}
+
+	for {
+		select {
+		case sig := <-ch:
+			if sig == syscall.SIGHUP {
+				reloadConfig("received signal SIGHUP")
+				continue
+			}
+			log.Infof("Terminating by signal %s, draining connections", sig)
+			r.Stop()
+			waitForStop(r, ch)
+			return
+		case <-reloadCh:
+			reloadConfig("config file modified")
+		}
+	}
+}
+
+// waitForStop waits for the router to drain its connections. Another termination signal makes it exit right away
+func waitForStop(r *router.MinecraftRouter, ch <-chan os.Signal) {
+	doneCh := make(chan struct{})
+	go func() {
+		r.Wait()
+		close(doneCh)
+	}()
+	for {
+		select {
+		case <-doneCh:
+			log.Infof("SMCR stopped")
+			return
+		case sig := <-ch:
+			if sig == syscall.SIGHUP {
+				continue
+			}
+			log.Warnf("Received signal %s again, exiting without waiting for the connections", sig)
+			return
+		}
+	}
+}
+
+// runCheck validates the config, from the config file or the environment variable, prints all problems found, and returns the exit code
+func runCheck(args []string) int {
+	flagSet := flag.NewFlagSet("check", flag.ExitOnError)
+	flagConfig := flagSet.String("c", "config.yml", "Path to the config yaml file")
+	_ = flagSet.Parse(args)
+
+	configSource := config.NewConfigSource(*flagConfig)
+	_, warnings, err := configSource.Load()
+	for _, warning := range warnings {
+		fmt.Printf("WARNING: %s\n", warning)
+	}
+	if err != nil {
+		if validationErr, ok := err.(*config.ValidationError); ok {
+			for _, problem := range validationErr.Problems {
+				fmt.Printf("ERROR: %s\n", problem)
+			}
+		} else {
+			fmt.Printf("ERROR: %v\n", err)
+		}
+		return 1
+	}
+	fmt.Printf("Config from %s is valid\n", configSource)
+	return 0
 }
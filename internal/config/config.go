@@ -3,11 +3,16 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/Fallen-Breath/smcr/internal/logging"
+	"github.com/Fallen-Breath/smcr/internal/protocol"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
 const DefaultRouteName = "default"
@@ -19,19 +24,118 @@ const (
 	Reject  RouteAction = "reject"  // reject and close the connection
 )
 
+type BalanceStrategy string
+
+const (
+	RoundRobin       BalanceStrategy = "round_robin"       // pick targets in turn
+	Weighted         BalanceStrategy = "weighted"          // pick targets randomly, with the probability proportional to their weights
+	LeastConnections BalanceStrategy = "least_connections" // pick the target with the fewest active connections per weight
+)
+
+type RouteTarget struct {
+	Address string `yaml:"address"`          // Port is optional (use 25565 if absent). Supports "${var}" captured from regex matches
+	Weight  int    `yaml:"weight,omitempty"` // optional, default 1
+}
+
+func (t *RouteTarget) UnmarshalYAML(value *yaml.Node) error {
+	// the default weight is filled here, so an explicit 0 can be told apart from an absent weight
+	t.Weight = 1
+	// a plain string is a shortcut for a target with the default weight
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&t.Address)
+	}
+	type plain RouteTarget
+	return value.Decode((*plain)(t))
+}
+
+const defaultOfflineVersionName = "SMCR"
+
+// OfflineStatus is the server list status SMCR responds with, if the target is unreachable
+type OfflineStatus struct {
+	Motd        string `yaml:"motd"`                   // mc message
+	VersionName string `yaml:"version_name,omitempty"` // optional, default defaultOfflineVersionName
+	Protocol    int32  `yaml:"protocol,omitempty"`     // optional, default: the protocol version of the client
+	MaxPlayers  int    `yaml:"max_players,omitempty"`  // optional
+	Favicon     string `yaml:"favicon,omitempty"`      // optional, path to a 64x64 png image
+
+	motdJson    string `yaml:"-"`
+	faviconData string `yaml:"-"` // data uri of the favicon image
+}
+
+// StatusProxy makes SMCR serve the status state by itself with the cached and rewritten target status
+type StatusProxy struct {
+	CacheTtl    time.Duration `yaml:"cache_ttl,omitempty"`    // optional, default 5s
+	Motd        string        `yaml:"motd,omitempty"`         // optional, mc message, override the MOTD
+	MaxPlayers  *int          `yaml:"max_players,omitempty"`  // optional, override the max player count
+	HidePlayers bool          `yaml:"hide_players,omitempty"` // optional, remove the sample player list
+
+	motdJson string `yaml:"-"`
+}
+
+type RateLimit struct {
+	Rate          float64 `yaml:"rate,omitempty"`             // new connections per second per ip, 0 means unlimited
+	Burst         int     `yaml:"burst,omitempty"`            // optional, size of the token bucket, default rate rounded up
+	Ipv6Prefix    int     `yaml:"ipv6_prefix,omitempty"`      // optional, default 64. IPv6 clients within the same prefix share the same limits
+	MaxConnsPerIp int     `yaml:"max_conns_per_ip,omitempty"` // max concurrent connections per ip, 0 means unlimited
+	MaxConns      int     `yaml:"max_conns,omitempty"`        // max concurrent connections in total, 0 means unlimited
+	Message       string  `yaml:"message,omitempty"`          // optional, mc message sent to over-limit login attempts
+
+	messageJson string `yaml:"-"`
+}
+
+type Shutdown struct {
+	GracePeriod time.Duration `yaml:"grace_period,omitempty"` // optional, default 30s. How long existing connections can keep going after the shutdown starts
+	Message     string        `yaml:"message,omitempty"`      // optional, mc message sent to login clients that are still in handshake when the shutdown starts
+
+	messageJson string `yaml:"-"`
+}
+
+type AccessLog struct {
+	File       string `yaml:"file"`                  // path to the access log file
+	MaxSize    int    `yaml:"max_size,omitempty"`    // optional, default 100. The file is rotated when it grows over this size in MiB
+	MaxBackups int    `yaml:"max_backups,omitempty"` // optional, default 5. Max amount of rotated files to keep
+}
+
 type Route struct {
 	Name    string      `yaml:"name"`
-	Matches []string    `yaml:"matches"`          // match any of them -> use this route. Port is optional. Addresses with port has higher priority
+	Matches []string    `yaml:"matches"`          // match any of them -> use this route. Port is optional. Addresses with port has higher priority. Supports "*.example.com" and ".example.com"
 	Action  RouteAction `yaml:"action,omitempty"` // how to deal with the client connection
 
+	// filters. A route is skipped if any of the filter does not accept the client
+	NextStates       []string `yaml:"next_states,omitempty"`       // if given, only accept handshakes with these next states: status, login, transfer
+	ProtocolVersions []string `yaml:"protocol_versions,omitempty"` // if given, only accept clients with these protocol versions, e.g. "47", "760-767"
+	Usernames        []string `yaml:"usernames,omitempty"`         // if given, only accept players with these names (case-insensitive). Requires ReadLoginStart
+	UsernameRegex    string   `yaml:"username_regex,omitempty"`    // if given, only accept players whose name matches this regex. Requires ReadLoginStart
+
+	// player lists, checked after the global ones. Requires ReadLoginStart
+	AllowedPlayers *PlayerList `yaml:"allowed_players,omitempty"` // if given, only players in the list can log in via this route
+	BannedPlayers  *PlayerList `yaml:"banned_players,omitempty"`  // if given, players in the list are disconnected
+
+	// ip filters, applied on top of the global (or listener) ones. Clients must pass both
+	WhitelistedIps []string `yaml:"whitelisted_ips,omitempty"`
+	BlacklistedIps []string `yaml:"blacklisted_ips,omitempty"`
+
+	UnsupportedVersionMessage string `yaml:"unsupported_version_message,omitempty"` // if given, disconnect clients rejected by ProtocolVersions with this message, instead of trying the next route
+
 	// forward action
-	Target          string        `yaml:"target,omitempty"`            // The target server to route for. Port is optional (use 25565 if absent)
-	Mimic           string        `yaml:"mimic,omitempty"`             // optional
-	Timeout         time.Duration `yaml:"timeout_ms,omitempty"`        // optional, default DefaultConnectTimeout
-	DialFailMessage string        `yaml:"dial_fail_message,omitempty"` // if given, send this to the client if dial failed
+	Target          string          `yaml:"target,omitempty"`            // The target server to route for. Port is optional (use 25565 if absent). Supports "${var}" captured from regex matches
+	Targets         []RouteTarget   `yaml:"targets,omitempty"`           // multiple targets to balance between. Cannot be used together with Target
+	Balance         BalanceStrategy `yaml:"balance,omitempty"`           // how to pick a target from Targets, default RoundRobin
+	Fallbacks       []string        `yaml:"fallbacks,omitempty"`         // targets to try in order, if all targets failed to connect
+	Mimic           string          `yaml:"mimic,omitempty"`             // optional. Supports "${var}" captured from regex matches
+	Timeout         time.Duration   `yaml:"timeout_ms,omitempty"`        // optional, default DefaultConnectTimeout
+	DialFailMessage string          `yaml:"dial_fail_message,omitempty"` // if given, send this to the client if dial failed
+	OfflineStatus   *OfflineStatus  `yaml:"offline_status,omitempty"`    // if given, respond to status pings with this if dial failed
+	StatusProxy     *StatusProxy    `yaml:"status_proxy,omitempty"`      // if given, serve status pings with the cached target status
 
 	// haproxy protocol
-	ProxyProtocol int `yaml:"proxy_protocol,omitempty"` // if given, send proxy protocol header to the target server using given version (1 or 2)
+	ProxyProtocol     int                `yaml:"proxy_protocol,omitempty"`      // if given, send proxy protocol header to the target server using given version (1 or 2)
+	ProxyProtocolTlvs *ProxyProtocolTlvs `yaml:"proxy_protocol_tlvs,omitempty"` // if given, send these TLVs in the header. Requires ProxyProtocol 2
+
+	// bungeecord ip forwarding. Requires ReadLoginStart
+	BungeeCordForwarding      bool   `yaml:"bungeecord_forwarding,omitempty"`        // if true, put the client ip and uuid into the hostname of login handshakes, like what BungeeCord does
+	BungeeGuardToken          string `yaml:"bungeeguard_token,omitempty"`            // if given, also send it as the bungeeguard-token property
+	BungeeCordTrustClientUuid bool   `yaml:"bungeecord_trust_client_uuid,omitempty"` // if true, send the unverified uuid claimed by the client, instead of the offline uuid of the player name
 
 	// reject action
 	RejectMessage string `yaml:"reject_message,omitempty"` // if given, disconnect the client with the given message, so client knows what happens
@@ -39,34 +143,52 @@ type Route struct {
 	// processed json version of RejectMessage and TimeoutMessage
 	rejectMessageJson   string `yaml:"-"`
 	dialFailMessageJson string `yaml:"-"`
-}
 
-type Config struct {
-	Listen                string        `yaml:"listen"`
-	Debug                 bool          `yaml:"debug"`
-	Routes                []Route       `yaml:"routes"`
-	DefaultConnectTimeout time.Duration `yaml:"default_connect_timeout"`   // optional, default 3s
-	SrvLookupTimeout      time.Duration `yaml:"srv_lookup_timeout"`        // optional, default 3s
-	ProxyProtocol         bool          `yaml:"proxy_protocol,omitempty"`  // if client can send proxy protocol header to smcr. if true, PP header will be required
-	WhitelistedIps        []string      `yaml:"whitelisted_ips,omitempty"` // if provided, only connections from these ips / domains will be accepted
-
-	routeMap     map[string]*Route `yaml:"-"` // match_addr (lowered case) -> route
-	defaultRoute *Route            `yaml:"-"`
+	nextStates                    map[int32]bool  `yaml:"-"`
+	protocolVersions              []protocolRange `yaml:"-"`
+	usernames                     map[string]bool `yaml:"-"`
+	usernameRegex                 *regexp.Regexp  `yaml:"-"`
+	unsupportedVersionMessageJson string          `yaml:"-"`
+	ipWhitelist                   *IpList         `yaml:"-"`
+	ipBlacklist                   *IpList         `yaml:"-"`
 }
 
-func validateAddress(what string, address string, mustWithPort bool) {
-	if len(address) == 0 {
-		log.Fatalf("Field %s is empty", what)
-	}
+type HealthCheck struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval,omitempty"` // optional, default 10s
+	Timeout  time.Duration `yaml:"timeout,omitempty"`  // optional, default 3s
+	Rise     int           `yaml:"rise,omitempty"`     // optional, default 2. Consecutive successful checks to mark a target up
+	Fall     int           `yaml:"fall,omitempty"`     // optional, default 3. Consecutive failed checks to mark a target down
+}
 
-	addrToTest := address
-	if !mustWithPort && !strings.Contains(address, ":") {
-		addrToTest = address + ":25565"
-	}
+type Config struct {
+	Listen                string             `yaml:"listen,omitempty"` // optional if Listeners is given
+	ProxyProtocolConfig   `yaml:",inline"`   // proxy protocol policy of the listener created by Listen
+	RealIp                RealIp             `yaml:"real_ip,omitempty"` // decode the real client ip in the handshake hostname, for the listener created by Listen
+	Debug                 bool               `yaml:"debug"`
+	Routes                []Route            `yaml:"routes"`
+	Listeners             []Listener         `yaml:"listeners,omitempty"`         // more addresses to listen on, each with its own rules
+	RouteSets             map[string][]Route `yaml:"route_sets,omitempty"`        // named route lists that can be shared by listeners
+	DefaultConnectTimeout time.Duration      `yaml:"default_connect_timeout"`     // optional, default 3s
+	SrvLookupTimeout      time.Duration      `yaml:"srv_lookup_timeout"`          // optional, default 3s
+	WhitelistedIps        []string           `yaml:"whitelisted_ips,omitempty"`   // if provided, only connections from these ips / CIDR blocks / domains will be accepted
+	BlacklistedIps        []string           `yaml:"blacklisted_ips,omitempty"`   // if provided, connections from these ips / CIDR blocks / domains will be rejected
+	IpDomainRefresh       time.Duration      `yaml:"ip_domain_refresh,omitempty"` // optional, default 1m. How often the domains in ip filters are resolved again
+	HealthCheck           HealthCheck        `yaml:"health_check,omitempty"`      // actively check if route targets are up with status pings
+	RateLimit             RateLimit          `yaml:"rate_limit,omitempty"`        // limit connections per ip and in total
+	MetricsListen         string             `yaml:"metrics_listen,omitempty"`    // if given, expose prometheus metrics at http://<metrics_listen>/metrics
+	Shutdown              Shutdown           `yaml:"shutdown,omitempty"`          // how to drain connections on shutdown
+	AdminListen           string             `yaml:"admin_listen,omitempty"`      // if given, serve the admin http api on this address. It has no authentication, so keep it local
+	LogFormat             string             `yaml:"log_format,omitempty"`        // optional, "text" (default) or "json"
+	AccessLog             *AccessLog         `yaml:"access_log,omitempty"`        // if given, write a json record for each finished connection to the file
+	ReadLoginStart        bool               `yaml:"read_login_start,omitempty"`  // also read the Login Start packet of login clients, so routes can filter by player names
+	AllowedPlayers        *PlayerList        `yaml:"allowed_players,omitempty"`   // if given, only players in the list can log in. Requires ReadLoginStart
+	BannedPlayers         *PlayerList        `yaml:"banned_players,omitempty"`    // if given, players in the list are disconnected. Requires ReadLoginStart
 
-	if _, _, err := net.SplitHostPort(addrToTest); err != nil {
-		log.Fatalf("Field '%s' with value %s is not a valid address: %v", what, address, err)
-	}
+	routeTables []*RouteTable `yaml:"-"` // the top-level routes first, then the routes of listeners, then route sets
+	listeners   []*Listener   `yaml:"-"` // the listener of the top-level listen first, then Listeners
+	ipWhitelist *IpList       `yaml:"-"`
+	ipBlacklist *IpList       `yaml:"-"`
 }
 
 func formatMessageJson(msg string) string {
@@ -78,7 +200,10 @@ func formatMessageJson(msg string) string {
 	}
 }
 
-func (c *Config) Init() {
+// Init fills default values, validates the config, and prepares it for use.
+// All invalid fields are reported in the returned *ValidationError.
+// Warnings are problems that do not prevent the config from being used, e.g. duplicated matches
+func (c *Config) Init() (warnings []string, err error) {
 	// set log level first
 	if c.Debug {
 		log.SetLevel(log.DebugLevel)
@@ -93,95 +218,401 @@ func (c *Config) Init() {
 	if c.SrvLookupTimeout <= 0 {
 		c.SrvLookupTimeout = 3 * time.Second
 	}
-	for i := range c.Routes {
-		route := &c.Routes[i]
-		if route.Timeout <= 0 {
-			route.Timeout = c.DefaultConnectTimeout
+	if len(c.LogFormat) == 0 {
+		c.LogFormat = logging.FormatText
+	}
+	if c.AccessLog != nil && c.AccessLog.MaxSize <= 0 {
+		c.AccessLog.MaxSize = 100
+	}
+	if c.AccessLog != nil && c.AccessLog.MaxBackups <= 0 {
+		c.AccessLog.MaxBackups = 5
+	}
+	if c.Shutdown.GracePeriod <= 0 {
+		c.Shutdown.GracePeriod = 30 * time.Second
+	}
+	if c.IpDomainRefresh <= 0 {
+		c.IpDomainRefresh = time.Minute
+	}
+	if c.RateLimit.Burst <= 0 {
+		c.RateLimit.Burst = int(math.Ceil(c.RateLimit.Rate))
+	}
+	if c.RateLimit.Ipv6Prefix <= 0 {
+		c.RateLimit.Ipv6Prefix = 64
+	}
+	if c.HealthCheck.Interval <= 0 {
+		c.HealthCheck.Interval = 10 * time.Second
+	}
+	if c.HealthCheck.Timeout <= 0 {
+		c.HealthCheck.Timeout = 3 * time.Second
+	}
+	if c.HealthCheck.Rise <= 0 {
+		c.HealthCheck.Rise = 2
+	}
+	if c.HealthCheck.Fall <= 0 {
+		c.HealthCheck.Fall = 3
+	}
+	for i := range c.Listeners {
+		if len(c.Listeners[i].Name) == 0 {
+			c.Listeners[i].Name = c.Listeners[i].Listen
 		}
-		if len(route.Action) == 0 {
-			route.Action = Forward
+	}
+	c.ProxyProtocolConfig.fillDefaults()
+	for i := range c.Listeners {
+		c.Listeners[i].ProxyProtocolConfig.fillDefaults()
+	}
+	c.routeTables = c.collectRouteTables()
+	for _, table := range c.routeTables {
+		for i := range table.Routes {
+			c.fillRouteDefaults(&table.Routes[i])
 		}
 	}
 
 	// validate
-	validateAddress("listen", c.Listen, true)
-	for i := range c.Routes {
-		route := &c.Routes[i]
-		for j := range route.Matches {
-			validateAddress(fmt.Sprintf("routes[%d]match[%d]", i, j), route.Matches[j], false)
-		}
-		if len(route.Target) > 0 {
-			validateAddress(fmt.Sprintf("routes[%d]target", i), route.Target, false)
-		} else {
-			log.Fatalf("routes[%d] does not specify the target", i)
-		}
-		if len(route.Mimic) > 0 {
-			validateAddress(fmt.Sprintf("routes[%d]mimic", i), route.Mimic, true)
-		}
-		switch route.Action {
-		case Forward, Reject:
-			// ok
-		default:
-			log.Fatalf("unknown route acion %s", route.Action)
+	v := &validator{}
+	if len(c.Listen) > 0 || len(c.Listeners) == 0 {
+		v.checkAddress("listen", c.Listen, true)
+	}
+	c.ProxyProtocolConfig.validate(v, "")
+	c.validateListeners(v)
+	if c.LogFormat != logging.FormatText && c.LogFormat != logging.FormatJson {
+		v.errorf("log_format", "unknown log format %s, should be %s or %s", c.LogFormat, logging.FormatText, logging.FormatJson)
+	}
+	if c.AccessLog != nil && len(c.AccessLog.File) == 0 {
+		v.errorf("access_log.file", "field is empty")
+	}
+	if len(c.MetricsListen) > 0 {
+		v.checkAddress("metrics_listen", c.MetricsListen, true)
+	}
+	if len(c.AdminListen) > 0 {
+		v.checkAddress("admin_listen", c.AdminListen, true)
+		if host, _, err := net.SplitHostPort(c.AdminListen); err == nil {
+			if ip := net.ParseIP(host); (ip == nil && host != "localhost") || (ip != nil && !ip.IsLoopback()) {
+				v.warnf("admin_listen", "%s is not a loopback address, and the admin api has no authentication", c.AdminListen)
+			}
 		}
-		if !(0 <= route.ProxyProtocol && route.ProxyProtocol <= 2) {
-			log.Fatalf("routes[%d] declares invalid proxy protocol version %d, should be 1 or 2", i, route.ProxyProtocol)
+	}
+	if c.RateLimit.Rate < 0 {
+		v.errorf("rate_limit.rate", "should not be negative")
+	}
+	if c.RateLimit.MaxConnsPerIp < 0 {
+		v.errorf("rate_limit.max_conns_per_ip", "should not be negative")
+	}
+	if c.RateLimit.MaxConns < 0 {
+		v.errorf("rate_limit.max_conns", "should not be negative")
+	}
+	if c.RateLimit.Ipv6Prefix > 128 {
+		v.errorf("rate_limit.ipv6_prefix", "%d is invalid, should be between 1 and 128", c.RateLimit.Ipv6Prefix)
+	}
+	if c.AllowedPlayers != nil && !c.ReadLoginStart {
+		v.errorf("allowed_players", "requires read_login_start to be enabled")
+	}
+	if c.BannedPlayers != nil && !c.ReadLoginStart {
+		v.errorf("banned_players", "requires read_login_start to be enabled")
+	}
+	for _, table := range c.routeTables {
+		for i := range table.Routes {
+			c.validateRoute(v, table.routePath(i), &table.Routes[i])
 		}
 	}
 
 	// adjust values
-	for i := range c.Routes {
-		route := &c.Routes[i]
-		if len(route.RejectMessage) > 0 {
-			route.rejectMessageJson = formatMessageJson(route.RejectMessage)
-		}
-		if len(route.DialFailMessage) > 0 {
-			route.dialFailMessageJson = formatMessageJson(route.DialFailMessage)
+	if len(c.RateLimit.Message) > 0 {
+		c.RateLimit.messageJson = formatMessageJson(c.RateLimit.Message)
+	}
+	if len(c.Shutdown.Message) > 0 {
+		c.Shutdown.messageJson = formatMessageJson(c.Shutdown.Message)
+	}
+	initPlayerList(v, "allowed_players", c.AllowedPlayers)
+	warnUuidAllowedPlayers(v, "allowed_players", c.AllowedPlayers)
+	initPlayerList(v, "banned_players", c.BannedPlayers)
+	c.ipWhitelist = c.initIpList(v, "whitelisted_ips", c.WhitelistedIps)
+	c.ipBlacklist = c.initIpList(v, "blacklisted_ips", c.BlacklistedIps)
+	c.proxyProtocolTrusted = c.initIpList(v, "proxy_protocol_trusted", c.ProxyProtocolTrusted)
+	c.RealIp.init(v, "real_ip", c.IpDomainRefresh)
+	for _, table := range c.routeTables {
+		for i := range table.Routes {
+			c.prepareRoute(v, table.routePath(i), &table.Routes[i])
 		}
 	}
+	c.prepareListeners(v)
 
 	// gather
-	c.routeMap = make(map[string]*Route)
-	c.defaultRoute = nil
-	for i := range c.Routes {
-		route := &c.Routes[i]
-		if route.Name == DefaultRouteName {
-			c.defaultRoute = route
-			if len(route.Matches) > 0 {
-				log.Warnf("'matches' field for default route is useless")
+	for _, table := range c.routeTables {
+		table.gather(v)
+	}
+
+	return v.warnings, v.err()
+}
+
+func (c *Config) fillRouteDefaults(route *Route) {
+	if route.Timeout <= 0 {
+		route.Timeout = c.DefaultConnectTimeout
+	}
+	if len(route.Action) == 0 {
+		route.Action = Forward
+	}
+	if len(route.Balance) == 0 {
+		route.Balance = RoundRobin
+	}
+	if route.StatusProxy != nil && route.StatusProxy.CacheTtl <= 0 {
+		route.StatusProxy.CacheTtl = 5 * time.Second
+	}
+}
+
+func (c *Config) validateRoute(v *validator, path string, route *Route) {
+	var regexVars []map[string]bool
+	for j := range route.Matches {
+		matchPath := fmt.Sprintf("%s.matches[%d]", path, j)
+		if strings.HasPrefix(route.Matches[j], RegexMatchPrefix) {
+			regex, err := compileMatchRegex(route.Matches[j])
+			if err != nil {
+				v.errorf(matchPath, "%s is not a valid match: %v", route.Matches[j], err)
+				continue
 			}
-		} else {
-			for _, addr := range route.Matches {
-				key := strings.ToLower(addr)
-				if existed, ok := c.routeMap[key]; ok {
-					log.Warnf("Duplicated route match %s, found in %s and %s", addr, existed.Name, route.Name)
+			vars := map[string]bool{}
+			for k, name := range regex.SubexpNames() {
+				vars[fmt.Sprintf("%d", k)] = true
+				vars[name] = true
+			}
+			regexVars = append(regexVars, vars)
+		} else if len(route.Matches[j]) == 0 {
+			v.errorf(matchPath, "field is empty")
+		} else if _, _, err := parseMatchPattern(route.Matches[j]); err != nil {
+			v.errorf(matchPath, "%s is not a valid match: %v", route.Matches[j], err)
+		}
+	}
+	type templateField struct {
+		path     string
+		template string
+	}
+	templates := []templateField{{path + ".target", route.Target}, {path + ".mimic", route.Mimic}}
+	for j, target := range route.Targets {
+		templates = append(templates, templateField{fmt.Sprintf("%s.targets[%d]", path, j), target.Address})
+	}
+	for j, fallback := range route.Fallbacks {
+		templates = append(templates, templateField{fmt.Sprintf("%s.fallbacks[%d]", path, j), fallback})
+	}
+	for _, field := range templates {
+		for _, name := range templateVars(field.template) {
+			if len(regexVars) == 0 {
+				v.errorf(field.path, "uses template variable ${%s}, but the route has no regex match", name)
+				continue
+			}
+			for _, vars := range regexVars {
+				if !vars[name] {
+					v.errorf(field.path, "uses template variable ${%s}, which is not a capture group in all regex matches of the route", name)
+					break
 				}
-				c.routeMap[key] = route
 			}
 		}
 	}
+	if len(route.Target) > 0 && len(route.Targets) > 0 {
+		v.errorf(path, "cannot specify both target and targets")
+	}
+	if len(route.Target) > 0 {
+		v.checkAddress(path+".target", route.Target, false)
+	} else if len(route.Targets) > 0 {
+		for j, target := range route.Targets {
+			v.checkAddress(fmt.Sprintf("%s.targets[%d]", path, j), target.Address, false)
+			if target.Weight <= 0 {
+				v.errorf(fmt.Sprintf("%s.targets[%d].weight", path, j), "should be positive, remove the target to stop sending players to it")
+			}
+		}
+	} else if route.Action == Forward {
+		v.errorf(path, "does not specify the target")
+	}
+	for j, fallback := range route.Fallbacks {
+		v.checkAddress(fmt.Sprintf("%s.fallbacks[%d]", path, j), fallback, false)
+	}
+	for j, name := range route.NextStates {
+		if _, ok := nextStateNames[strings.ToLower(name)]; !ok {
+			v.errorf(fmt.Sprintf("%s.next_states[%d]", path, j), "unknown next state %s, should be one of status, login or transfer", name)
+		}
+	}
+	for j, versions := range route.ProtocolVersions {
+		if _, err := parseProtocolRange(versions); err != nil {
+			v.errorf(fmt.Sprintf("%s.protocol_versions[%d]", path, j), "%s is invalid: %v", versions, err)
+		}
+	}
+	if len(route.UsernameRegex) > 0 {
+		if _, err := regexp.Compile(route.UsernameRegex); err != nil {
+			v.errorf(path+".username_regex", "%s is invalid: %v", route.UsernameRegex, err)
+		}
+	}
+	if len(route.Usernames) > 0 && !c.ReadLoginStart {
+		v.errorf(path+".usernames", "requires read_login_start to be enabled")
+	}
+	if len(route.UsernameRegex) > 0 && !c.ReadLoginStart {
+		v.errorf(path+".username_regex", "requires read_login_start to be enabled")
+	}
+	if route.AllowedPlayers != nil && !c.ReadLoginStart {
+		v.errorf(path+".allowed_players", "requires read_login_start to be enabled")
+	}
+	if route.BannedPlayers != nil && !c.ReadLoginStart {
+		v.errorf(path+".banned_players", "requires read_login_start to be enabled")
+	}
+	switch route.Balance {
+	case RoundRobin, Weighted, LeastConnections:
+		// ok
+	default:
+		v.errorf(path+".balance", "unknown balance strategy %s", route.Balance)
+	}
+	if len(route.Mimic) > 0 {
+		v.checkAddress(path+".mimic", route.Mimic, true)
+	}
+	switch route.Action {
+	case Forward, Reject:
+		// ok
+	default:
+		v.errorf(path+".action", "unknown route action %s", route.Action)
+	}
+	if !(0 <= route.ProxyProtocol && route.ProxyProtocol <= 2) {
+		v.errorf(path+".proxy_protocol", "invalid proxy protocol version %d, should be 1 or 2", route.ProxyProtocol)
+	}
+	if route.ProxyProtocolTlvs != nil {
+		route.ProxyProtocolTlvs.validate(v, path+".proxy_protocol_tlvs", route.ProxyProtocol)
+	}
+	if route.BungeeCordForwarding && !c.ReadLoginStart {
+		v.errorf(path+".bungeecord_forwarding", "requires read_login_start to be enabled")
+	}
+	if len(route.BungeeGuardToken) > 0 && !route.BungeeCordForwarding {
+		v.warnf(path+".bungeeguard_token", "useless since bungeecord_forwarding is not enabled")
+	}
+	if route.BungeeCordTrustClientUuid {
+		if !route.BungeeCordForwarding {
+			v.warnf(path+".bungeecord_trust_client_uuid", "useless since bungeecord_forwarding is not enabled")
+		} else {
+			v.warnf(path+".bungeecord_trust_client_uuid", "the uuid is claimed by the client and not verified, players can log in to the target with the uuid of others")
+		}
+	}
+}
+
+// prepareRoute loads the files and builds the lookup structures of the route
+func (c *Config) prepareRoute(v *validator, path string, route *Route) {
+	initPlayerList(v, path+".allowed_players", route.AllowedPlayers)
+	warnUuidAllowedPlayers(v, path+".allowed_players", route.AllowedPlayers)
+	initPlayerList(v, path+".banned_players", route.BannedPlayers)
+	route.ipWhitelist = c.initIpList(v, path+".whitelisted_ips", route.WhitelistedIps)
+	route.ipBlacklist = c.initIpList(v, path+".blacklisted_ips", route.BlacklistedIps)
+	if len(route.Target) > 0 {
+		route.Targets = []RouteTarget{{Address: route.Target, Weight: 1}}
+	}
+	if len(route.NextStates) > 0 {
+		route.nextStates = make(map[int32]bool)
+		for _, name := range route.NextStates {
+			route.nextStates[nextStateNames[strings.ToLower(name)]] = true
+		}
+	}
+	for _, versions := range route.ProtocolVersions {
+		if r, err := parseProtocolRange(versions); err == nil {
+			route.protocolVersions = append(route.protocolVersions, r)
+		}
+	}
+	if len(route.Usernames) > 0 {
+		route.usernames = make(map[string]bool)
+		for _, name := range route.Usernames {
+			route.usernames[strings.ToLower(name)] = true
+		}
+	}
+	if len(route.UsernameRegex) > 0 {
+		route.usernameRegex, _ = regexp.Compile(route.UsernameRegex)
+	}
+	if len(route.UnsupportedVersionMessage) > 0 {
+		route.unsupportedVersionMessageJson = formatMessageJson(route.UnsupportedVersionMessage)
+	}
+	if len(route.RejectMessage) > 0 {
+		route.rejectMessageJson = formatMessageJson(route.RejectMessage)
+	}
+	if len(route.DialFailMessage) > 0 {
+		route.dialFailMessageJson = formatMessageJson(route.DialFailMessage)
+	}
+	if route.StatusProxy != nil && len(route.StatusProxy.Motd) > 0 {
+		route.StatusProxy.motdJson = formatMessageJson(route.StatusProxy.Motd)
+	}
+	if status := route.OfflineStatus; status != nil {
+		status.motdJson = formatMessageJson(status.Motd)
+		if len(status.VersionName) == 0 {
+			status.VersionName = defaultOfflineVersionName // clients show it when the protocol does not match, so it should not be empty
+		}
+		if len(status.Favicon) > 0 {
+			data, err := loadFavicon(status.Favicon)
+			if err != nil {
+				v.errorf(path+".offline_status.favicon", "failed to load favicon: %v", err)
+			}
+			status.faviconData = data
+		}
+	}
+}
+
+func initPlayerList(v *validator, path string, list *PlayerList) {
+	if list != nil {
+		if err := list.init(); err != nil {
+			v.errorf(path+".file", "failed to load %s: %v", list.File, err)
+		}
+	}
+}
+
+// warnUuidAllowedPlayers warns about uuids in allowed_players, since the client can claim any uuid,
+// and players are only allowed by their names
+func warnUuidAllowedPlayers(v *validator, path string, list *PlayerList) {
+	if list != nil && list.static != nil && list.hasUuidOnlyPlayers() {
+		v.warnf(path+".players", "uuids are ignored in allowed players, since clients can claim any uuid. Use player names instead")
+	}
+}
+
+func (c *Config) initIpList(v *validator, path string, entries []string) *IpList {
+	if len(entries) == 0 {
+		return nil
+	}
+	list, err := newIpList(entries, c.IpDomainRefresh)
+	if err != nil {
+		v.errorf(path, "%v", err)
+	}
+	return list
 }
 
 func (c *Config) Dump() {
 	sr := func(r *Route) string {
-		s := r.Target
+		var addresses []string
+		for _, target := range r.Targets {
+			addresses = append(addresses, target.Address)
+		}
+		s := strings.Join(addresses, ", ")
+		if len(addresses) > 1 {
+			s = fmt.Sprintf("[%s] (%s)", s, r.Balance)
+		}
+		if len(r.Fallbacks) > 0 {
+			s += fmt.Sprintf(" (fallbacks %s)", strings.Join(r.Fallbacks, ", "))
+		}
 		if len(r.Mimic) > 0 {
 			s += fmt.Sprintf(" (mimic %s)", r.Mimic)
 		}
 		return s
 	}
 
-	log.Debugf("Route map (len=%d):", len(c.routeMap))
-	for addr, route := range c.routeMap {
-		log.Debugf("- %s -> %s", addr, sr(route))
-	}
-	if c.defaultRoute != nil {
-		log.Debugf("* default route -> %s", sr(c.defaultRoute))
+	for _, listener := range c.listeners {
+		table := listener.routes
+		log.Debugf("Listener '%s' on %s, using %s", listener.Name, listener.Listen, table.Name)
+		log.Debugf("Route matches (len=%d):", table.routeMatcher.Len())
+		for _, entry := range table.routeMatcher.entries {
+			log.Debugf("- %s -> %s", entry.pattern, sr(entry.route))
+		}
+		if table.defaultRoute != nil {
+			log.Debugf("* default route -> %s", sr(table.defaultRoute))
+		}
 	}
 }
 
 // ---------------------- getters ----------------------
 
+func (r *RateLimit) GetMessageJson() string {
+	return r.messageJson
+}
+
+func (s *Shutdown) GetMessageJson() string {
+	return s.messageJson
+}
+
 func (r *Route) GetRejectMessageJson() string {
 	return r.rejectMessageJson
 }
@@ -190,10 +621,78 @@ func (r *Route) GetDialFailMessageJson() string {
 	return r.dialFailMessageJson
 }
 
-func (c *Config) GetRouteMap() map[string]*Route {
-	return c.routeMap
+func (r *Route) GetUnsupportedVersionMessageJson() string {
+	return r.unsupportedVersionMessageJson
+}
+
+// GetStatusJson returns the status response json for a client with the given protocol version
+func (s *OfflineStatus) GetStatusJson(clientProtocol int32) string {
+	response := protocol.StatusResponse{
+		Version: protocol.StatusVersion{
+			Name:     s.VersionName,
+			Protocol: s.Protocol,
+		},
+		Players: protocol.StatusPlayers{
+			Max: s.MaxPlayers,
+		},
+		Description: json.RawMessage(s.motdJson),
+		Favicon:     s.faviconData,
+	}
+	if response.Version.Protocol == 0 {
+		response.Version.Protocol = clientProtocol
+	}
+	b, _ := json.Marshal(response)
+	return string(b)
 }
 
-func (c *Config) GetDefaultRoute() *Route {
-	return c.defaultRoute
+// GetLegacyStatus returns the status for legacy server list pings.
+// clientProtocol is 0 for legacy clients before 1.6, which do not tell their protocol version
+func (s *OfflineStatus) GetLegacyStatus(clientProtocol int32) *protocol.LegacyStatus {
+	status := &protocol.LegacyStatus{
+		Protocol: s.Protocol,
+		Version:  s.VersionName,
+		Motd:     protocol.TextComponentToPlain(s.motdJson),
+		Max:      s.MaxPlayers,
+	}
+	if status.Protocol == 0 {
+		status.Protocol = clientProtocol
+	}
+	return status
+}
+
+// Rewrite applies the rewrite rules to the given status response json.
+// Unknown fields in the json are kept as-is
+func (p *StatusProxy) Rewrite(statusJson string) (string, error) {
+	var status map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(statusJson), &status); err != nil {
+		return "", err
+	}
+
+	if len(p.motdJson) > 0 {
+		status["description"] = json.RawMessage(p.motdJson)
+	}
+	if p.MaxPlayers != nil || p.HidePlayers {
+		var players map[string]json.RawMessage
+		if raw, ok := status["players"]; ok {
+			if err := json.Unmarshal(raw, &players); err != nil {
+				return "", fmt.Errorf("invalid players field: %v", err)
+			}
+		}
+		if players == nil {
+			players = make(map[string]json.RawMessage)
+		}
+		if p.MaxPlayers != nil {
+			players["max"], _ = json.Marshal(*p.MaxPlayers)
+		}
+		if p.HidePlayers {
+			delete(players, "sample")
+		}
+		status["players"], _ = json.Marshal(players)
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
 }
@@ -0,0 +1,340 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+	"gopkg.in/yaml.v3"
+)
+
+func initTestConfig(t *testing.T, content string) ([]string, error) {
+	var config Config
+	if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatalf("Failed to parse yaml: %v", err)
+	}
+	return config.Init()
+}
+
+func TestConfigValid(t *testing.T) {
+	warnings, err := initTestConfig(t, `
+listen: 0.0.0.0:7777
+routes:
+  - name: foo
+    matches: [mc.example.com]
+    target: 127.0.0.1:25566
+  - name: bar
+    matches: [mc.example.com]
+    target: 127.0.0.1:25567
+  - name: baz
+    matches: [bad.example.com]
+    action: reject
+`)
+	if err != nil {
+		t.Fatalf("Config should be valid: %v", err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "routes[1].matches[0]: duplicated route match") {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+}
+
+func TestConfigValidationErrors(t *testing.T) {
+	_, err := initTestConfig(t, `
+listen: 0.0.0.0
+routes:
+  - name: foo
+    matches: ['*', 're:(?P<srv>[a-z]+)\.example\.com']
+    target: '${srv}:${port}'
+  - name: bar
+    matches: [mc.example.com]
+    targets:
+      - address: 127.0.0.1:25566
+        weight: -1
+      - address: 127.0.0.1:25567
+        weight: 0
+    balance: random
+    next_states: [status, play]
+    usernames: [Steve]
+    proxy_protocol: 3
+    proxy_protocol_tlvs:
+      route_name_type: 0x10
+    bungeecord_forwarding: true
+  - name: baz
+    matches: [other.example.com]
+`)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, found %v", err)
+	}
+
+	expectedPaths := []string{
+		"listen",
+		"routes[0].matches[0]",
+		"routes[0].target", // ${port} is not a capture group
+		"routes[1].targets[0].weight",
+		"routes[1].targets[1].weight",
+		"routes[1].next_states[1]",
+		"routes[1].usernames",
+		"routes[1].balance",
+		"routes[1].proxy_protocol",
+		"routes[1].proxy_protocol_tlvs", // requires version 2
+		"routes[1].proxy_protocol_tlvs.route_name_type",
+		"routes[1].bungeecord_forwarding", // read_login_start is not enabled
+		"routes[2]",                       // no target
+	}
+	if len(validationErr.Problems) != len(expectedPaths) {
+		t.Errorf("Expected %d problems, found %d: %v", len(expectedPaths), len(validationErr.Problems), validationErr.Problems)
+	}
+	for _, path := range expectedPaths {
+		found := false
+		for _, problem := range validationErr.Problems {
+			if strings.HasPrefix(problem, path+": ") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Problem of %s is not reported, found %v", path, validationErr.Problems)
+		}
+	}
+}
+
+func TestConfigListeners(t *testing.T) {
+	var config Config
+	if err := yaml.Unmarshal([]byte(`
+listen: 0.0.0.0:7777
+whitelisted_ips: [10.0.0.0/8]
+routes:
+  - name: public
+    matches: [mc.example.com]
+    target: 127.0.0.1:25566
+listeners:
+  - name: lan
+    listen: 192.168.1.2:7777
+    whitelisted_ips: [192.168.0.0/16]
+    route_set: shared
+  - listen: 127.0.0.1:7778
+    proxy_protocol: true
+    routes:
+      - name: tunnel
+        matches: [mc.example.com]
+        target: 127.0.0.1:25567
+route_sets:
+  shared:
+    - name: lan
+      matches: [mc.example.com]
+      target: 127.0.0.1:25568
+  unused: []
+`), &config); err != nil {
+		t.Fatalf("Failed to parse yaml: %v", err)
+	}
+	warnings, err := config.Init()
+	if err != nil {
+		t.Fatalf("Config should be valid: %v", err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "route_sets.unused: ") {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+
+	expected := map[string]string{DefaultListenerName: "public", "lan": "lan", "127.0.0.1:7778": "tunnel"}
+	if len(config.GetListeners()) != len(expected) {
+		t.Fatalf("Unexpected listener count %d", len(config.GetListeners()))
+	}
+	for name, routeName := range expected {
+		listener := config.GetListener(name)
+		if listener == nil {
+			t.Fatalf("Listener %s not found", name)
+		}
+		if match := listener.GetRoutes().GetRouteMatcher().Match("mc.example.com", 25565); match == nil || match.Route.Name != routeName {
+			t.Errorf("Listener %s should route to %s, found %v", name, routeName, match)
+		}
+	}
+
+	// listener ip filters override the global ones
+	whitelist, _ := config.GetListener("lan").GetIpFilters(nil)
+	if whitelist == nil || !whitelist.Contains(net.ParseIP("192.168.1.3")) || whitelist.Contains(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Unexpected whitelist of the lan listener")
+	}
+	if whitelist, _ := config.GetListener("127.0.0.1:7778").GetIpFilters(nil); whitelist == nil || !whitelist.Contains(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Listener without ip filters should use the global ones")
+	}
+}
+
+func TestConfigListenersInvalid(t *testing.T) {
+	_, err := initTestConfig(t, `
+listeners:
+  - name: a
+    listen: 0.0.0.0:7777
+    route_set: missing
+  - name: a
+    listen: 0.0.0.0:7777
+`)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, found %v", err)
+	}
+	expectedPrefixes := []string{"listeners[0].route_set: ", "listeners[1].listen: ", "listeners[1].name: "}
+	if len(validationErr.Problems) != len(expectedPrefixes) {
+		t.Fatalf("Unexpected problems %v", validationErr.Problems)
+	}
+	for i, prefix := range expectedPrefixes {
+		if !strings.HasPrefix(validationErr.Problems[i], prefix) {
+			t.Errorf("Problem %q should start with %q", validationErr.Problems[i], prefix)
+		}
+	}
+}
+
+func TestConfigProxyProtocolPolicy(t *testing.T) {
+	var config Config
+	if err := yaml.Unmarshal([]byte(`
+listen: 0.0.0.0:7777
+proxy_protocol: true
+routes: []
+listeners:
+  - name: edge
+    listen: 0.0.0.0:7778
+    proxy_protocol: true
+    proxy_protocol_trusted: [10.0.0.0/8]
+    proxy_protocol_trusted_policy: use
+    proxy_protocol_untrusted_policy: reject
+    proxy_protocol_header_timeout: 3s
+`), &config); err != nil {
+		t.Fatalf("Failed to parse yaml: %v", err)
+	}
+	if _, err := config.Init(); err != nil {
+		t.Fatalf("Config should be valid: %v", err)
+	}
+
+	// all upstreams are trusted if no trusted list is given
+	defaultListener := config.GetListener(DefaultListenerName)
+	if policy := defaultListener.GetUpstreamPolicy(net.ParseIP("1.2.3.4")); policy != ProxyProtocolRequire {
+		t.Errorf("Unexpected policy %s for the default listener", policy)
+	}
+	if defaultListener.ProxyProtocolHeaderTimeout != 10*time.Second {
+		t.Errorf("Unexpected default header timeout %s", defaultListener.ProxyProtocolHeaderTimeout)
+	}
+
+	edge := config.GetListener("edge")
+	if policy := edge.GetUpstreamPolicy(net.ParseIP("10.1.2.3")); policy != ProxyProtocolUse {
+		t.Errorf("Unexpected policy %s for trusted upstream", policy)
+	}
+	if policy := edge.GetUpstreamPolicy(net.ParseIP("1.2.3.4")); policy != ProxyProtocolReject {
+		t.Errorf("Unexpected policy %s for untrusted upstream", policy)
+	}
+	if edge.ProxyProtocolHeaderTimeout != 3*time.Second {
+		t.Errorf("Unexpected header timeout %s", edge.ProxyProtocolHeaderTimeout)
+	}
+}
+
+func loadTestConfig(t *testing.T, content string) *Config {
+	var config Config
+	if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatalf("Failed to parse yaml: %v", err)
+	}
+	if _, err := config.Init(); err != nil {
+		t.Fatalf("Failed to init config: %v", err)
+	}
+	return &config
+}
+
+func TestOfflineStatus(t *testing.T) {
+	config := loadTestConfig(t, `
+listen: 0.0.0.0:7777
+routes:
+  - name: default_version
+    matches: [a.example.com]
+    target: 127.0.0.1:25566
+    offline_status:
+      motd: Server is restarting
+      max_players: 20
+  - name: fixed_version
+    matches: [b.example.com]
+    target: 127.0.0.1:25567
+    offline_status:
+      motd: '{"text": "Maintenance", "color": "yellow"}'
+      version_name: maintenance
+      protocol: 767
+`)
+	defaultVersion := config.GetRouteTables()[0].Routes[0].OfflineStatus
+	fixedVersion := config.GetRouteTables()[0].Routes[1].OfflineStatus
+
+	for _, tc := range []struct {
+		status   *OfflineStatus
+		protocol int32
+		expected string
+	}{
+		{defaultVersion, 763, `{"version":{"name":"SMCR","protocol":763},"players":{"max":20,"online":0},"description":"Server is restarting"}`},
+		{fixedVersion, 763, `{"version":{"name":"maintenance","protocol":767},"players":{"max":0,"online":0},"description":{"text":"Maintenance","color":"yellow"}}`},
+	} {
+		if actual := tc.status.GetStatusJson(tc.protocol); actual != tc.expected {
+			t.Errorf("Expected status json %s, found %s", tc.expected, actual)
+		}
+	}
+
+	for _, tc := range []struct {
+		status   *OfflineStatus
+		protocol int32
+		expected protocol.LegacyStatus
+	}{
+		{defaultVersion, 78, protocol.LegacyStatus{Protocol: 78, Version: "SMCR", Motd: "Server is restarting", Max: 20}},
+		{defaultVersion, 0, protocol.LegacyStatus{Protocol: 0, Version: "SMCR", Motd: "Server is restarting", Max: 20}},
+		{fixedVersion, 78, protocol.LegacyStatus{Protocol: 767, Version: "maintenance", Motd: "Maintenance"}},
+	} {
+		if actual := tc.status.GetLegacyStatus(tc.protocol); *actual != tc.expected {
+			t.Errorf("Expected legacy status %+v, found %+v", tc.expected, *actual)
+		}
+	}
+}
+
+func TestStatusProxyRewrite(t *testing.T) {
+	config := loadTestConfig(t, `
+listen: 0.0.0.0:7777
+routes:
+  - name: motd
+    matches: [a.example.com]
+    target: 127.0.0.1:25566
+    status_proxy:
+      motd: Welcome
+  - name: hide_players
+    matches: [b.example.com]
+    target: 127.0.0.1:25567
+    status_proxy:
+      hide_players: true
+  - name: max_players
+    matches: [c.example.com]
+    target: 127.0.0.1:25568
+    status_proxy:
+      max_players: 100
+  - name: passthrough
+    matches: [d.example.com]
+    target: 127.0.0.1:25569
+    status_proxy: {}
+`)
+	routes := config.GetRouteTables()[0].Routes
+	status := `{"version":{"name":"1.21","protocol":767},"players":{"max":20,"online":2,"sample":[{"name":"Steve","id":"069a79f4-44e9-4726-a5be-fca90e38aaf5"}]},"description":"A Minecraft Server","enforcesSecureChat":true}`
+
+	for i, expected := range []string{
+		`{"description":"Welcome","enforcesSecureChat":true,"players":{"max":20,"online":2,"sample":[{"name":"Steve","id":"069a79f4-44e9-4726-a5be-fca90e38aaf5"}]},"version":{"name":"1.21","protocol":767}}`,
+		`{"description":"A Minecraft Server","enforcesSecureChat":true,"players":{"max":20,"online":2},"version":{"name":"1.21","protocol":767}}`,
+		`{"description":"A Minecraft Server","enforcesSecureChat":true,"players":{"max":100,"online":2,"sample":[{"name":"Steve","id":"069a79f4-44e9-4726-a5be-fca90e38aaf5"}]},"version":{"name":"1.21","protocol":767}}`,
+		`{"description":"A Minecraft Server","enforcesSecureChat":true,"players":{"max":20,"online":2,"sample":[{"name":"Steve","id":"069a79f4-44e9-4726-a5be-fca90e38aaf5"}]},"version":{"name":"1.21","protocol":767}}`,
+	} {
+		actual, err := routes[i].StatusProxy.Rewrite(status)
+		if err != nil {
+			t.Errorf("Route %s: unexpected error %v", routes[i].Name, err)
+		} else if actual != expected {
+			t.Errorf("Route %s: expected %s, found %s", routes[i].Name, expected, actual)
+		}
+	}
+
+	if actual, err := routes[2].StatusProxy.Rewrite(`{"description":"No players field"}`); err != nil || actual != `{"description":"No players field","players":{"max":100}}` {
+		t.Errorf("Missing players field should be created, found %s, %v", actual, err)
+	}
+	for _, s := range []string{`not json`, `{"players":"invalid"}`} {
+		if actual, err := routes[1].StatusProxy.Rewrite(s); err == nil {
+			t.Errorf("Rewrite(%q) should fail, found %s", s, actual)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+)
+
+var nextStateNames = map[string]int32{
+	"status":   protocol.HandshakeNextStateStatus,
+	"login":    protocol.HandshakeNextStateLogin,
+	"transfer": protocol.HandshakeNextStateTransfer,
+}
+
+// RouteQuery contains the client information that route filters check against
+type RouteQuery struct {
+	Hostname  string
+	Port      uint16
+	NextState int32 // legacy server list pings are treated as protocol.HandshakeNextStateStatus
+	Protocol  int32
+	IsLegacy  bool   // if it's a legacy server list ping, which uses a different protocol version numbering
+	Username  string // from the Login Start packet. Empty if it's not read
+}
+
+type protocolRange struct {
+	min int32
+	max int32
+}
+
+// parseProtocolRange parses "47" or "760-767"
+func parseProtocolRange(s string) (protocolRange, error) {
+	parse := func(v string) (int32, error) {
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid protocol version %s", v)
+		}
+		return int32(n), nil
+	}
+
+	minStr, maxStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		maxStr = minStr
+	}
+	minValue, err := parse(minStr)
+	if err != nil {
+		return protocolRange{}, err
+	}
+	maxValue, err := parse(maxStr)
+	if err != nil {
+		return protocolRange{}, err
+	}
+	if minValue > maxValue {
+		return protocolRange{}, fmt.Errorf("range start %d is greater than range end %d", minValue, maxValue)
+	}
+	return protocolRange{min: minValue, max: maxValue}, nil
+}
+
+// Accepts checks if all filters of the route, except the protocol version filter, accept the client
+func (r *Route) Accepts(query *RouteQuery) bool {
+	if r.nextStates != nil && !r.nextStates[query.NextState] {
+		return false
+	}
+	if r.usernames != nil || r.usernameRegex != nil {
+		if len(query.Username) == 0 {
+			return false
+		}
+		matched := r.usernames[strings.ToLower(query.Username)] || (r.usernameRegex != nil && r.usernameRegex.MatchString(query.Username))
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// AcceptsProtocol checks if the protocol version filter of the route accepts the client
+func (r *Route) AcceptsProtocol(query *RouteQuery) bool {
+	if len(r.protocolVersions) == 0 {
+		return true
+	}
+	if query.IsLegacy {
+		return false
+	}
+	for _, pr := range r.protocolVersions {
+		if pr.min <= query.Protocol && query.Protocol <= pr.max {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) hasFilters() bool {
+	return len(r.NextStates) > 0 || len(r.ProtocolVersions) > 0 || len(r.Usernames) > 0 || len(r.UsernameRegex) > 0
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseProtocolRange(t *testing.T) {
+	for s, expected := range map[string]protocolRange{
+		"47":         {min: 47, max: 47},
+		"760-767":    {min: 760, max: 767},
+		" 760 - 767": {min: 760, max: 767},
+		"767-767":    {min: 767, max: 767},
+	} {
+		if actual, err := parseProtocolRange(s); err != nil || actual != expected {
+			t.Errorf("parseProtocolRange(%q) = %v, %v, expected %v", s, actual, err, expected)
+		}
+	}
+	for _, s := range []string{"767-760", "", "abc", "47-", "-47", "1.8", "760-767-770", "99999999999"} {
+		if actual, err := parseProtocolRange(s); err == nil {
+			t.Errorf("parseProtocolRange(%q) should fail, found %v", s, actual)
+		}
+	}
+}
+
+func TestRouteAcceptsProtocol(t *testing.T) {
+	route := &Route{}
+	if !route.AcceptsProtocol(&RouteQuery{Protocol: 47}) || !route.AcceptsProtocol(&RouteQuery{IsLegacy: true}) {
+		t.Errorf("Route without protocol_versions should accept any protocol")
+	}
+
+	for _, s := range []string{"47", "760-767"} {
+		pr, err := parseProtocolRange(s)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", s, err)
+		}
+		route.protocolVersions = append(route.protocolVersions, pr)
+	}
+	for protocol, expected := range map[int32]bool{
+		47:  true,
+		48:  false,
+		759: false,
+		760: true,
+		763: true,
+		767: true,
+		768: false,
+		-1:  false,
+	} {
+		if actual := route.AcceptsProtocol(&RouteQuery{Protocol: protocol}); actual != expected {
+			t.Errorf("AcceptsProtocol(%d) = %v, expected %v", protocol, actual, expected)
+		}
+	}
+	if route.AcceptsProtocol(&RouteQuery{Protocol: 47, IsLegacy: true}) {
+		t.Errorf("Legacy server list pings should not be accepted by protocol_versions")
+	}
+}
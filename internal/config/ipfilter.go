@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IpList is a list of ips, CIDR blocks and domains, used for filtering client ips.
+// Domains are resolved in Config.Init, and refreshed in the background when the resolved ips become stale
+type IpList struct {
+	nets    []*net.IPNet
+	domains []*ipListDomain
+}
+
+type ipListDomain struct {
+	name            string
+	refreshInterval time.Duration
+
+	mutex      sync.Mutex
+	ips        []net.IP
+	resolvedAt time.Time
+	refreshing bool
+}
+
+func newIpList(entries []string, refreshInterval time.Duration) (*IpList, error) {
+	l := &IpList{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR block %s: %v", entry, err)
+			}
+			l.nets = append(l.nets, ipNet)
+		} else if ip := net.ParseIP(entry); ip != nil {
+			l.nets = append(l.nets, singleIpNet(ip))
+		} else if len(entry) > 0 {
+			l.domains = append(l.domains, &ipListDomain{name: entry, refreshInterval: refreshInterval})
+		} else {
+			return nil, fmt.Errorf("empty entry")
+		}
+	}
+	for _, domain := range l.domains {
+		domain.refresh()
+	}
+	return l, nil
+}
+
+func singleIpNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func (d *ipListDomain) refresh() {
+	ips, err := net.LookupIP(d.name)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.refreshing = false
+	d.resolvedAt = time.Now()
+	if err != nil {
+		log.Warnf("Failed to resolve ip list domain %s, keep using %d previously resolved ips: %v", d.name, len(d.ips), err)
+		return
+	}
+	d.ips = ips
+}
+
+// get returns the resolved ips, and triggers a background refresh if they are stale
+func (d *ipListDomain) get() []net.IP {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.refreshing && time.Since(d.resolvedAt) >= d.refreshInterval {
+		d.refreshing = true
+		go d.refresh()
+	}
+	return d.ips
+}
+
+// Contains checks if the ip is in the list. It never blocks on dns lookups
+func (l *IpList) Contains(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, ipNet := range l.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	for _, domain := range l.domains {
+		for _, domainIp := range domain.get() {
+			if domainIp.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckIp checks if the ip is accepted by the given whitelist and blacklist. Nil lists are ignored
+func CheckIp(ip net.IP, whitelist *IpList, blacklist *IpList) bool {
+	if blacklist != nil && blacklist.Contains(ip) {
+		return false
+	}
+	if whitelist != nil && !whitelist.Contains(ip) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIpList(t *testing.T) {
+	whitelist, err := newIpList([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create ip list: %v", err)
+	}
+	blacklist, err := newIpList([]string{"10.1.0.0/16"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create ip list: %v", err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"10.2.3.4":         true,
+		"::ffff:10.2.3.4":  true, // ipv4-mapped ipv6
+		"10.1.2.3":         false,
+		"192.168.1.1":      true,
+		"192.168.1.2":      false,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if actual := CheckIp(net.ParseIP(ip), whitelist, blacklist); actual != expected {
+			t.Errorf("CheckIp(%s) = %v, expected %v", ip, actual, expected)
+		}
+	}
+	if !CheckIp(net.ParseIP("1.2.3.4"), nil, blacklist) {
+		t.Errorf("Nil whitelist should accept all ips")
+	}
+
+	for _, entries := range [][]string{{"10.0.0.0/33"}, {"1.2.3.4/abc"}, {""}} {
+		if _, err := newIpList(entries, time.Minute); err == nil {
+			t.Errorf("Entries %v should be invalid", entries)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultListenerName is the name of the listener created by the top-level listen
+const DefaultListenerName = "default"
+
+// Listener is an address SMCR listens on, with its own proxy protocol policy, ip filters and routes
+type Listener struct {
+	Name                string           `yaml:"name,omitempty"` // optional, default the listen address. Used in logs
+	Listen              string           `yaml:"listen"`         // the address to listen on
+	ProxyProtocolConfig `yaml:",inline"` // proxy protocol policy of this listener
+	RealIp              RealIp           `yaml:"real_ip,omitempty"`         // decode the real client ip in the handshake hostname
+	WhitelistedIps      []string         `yaml:"whitelisted_ips,omitempty"` // if given, override the global ones for this listener
+	BlacklistedIps      []string         `yaml:"blacklisted_ips,omitempty"` // if given, override the global ones for this listener
+	Routes              []Route          `yaml:"routes,omitempty"`          // routes of this listener only
+	RouteSet            string           `yaml:"route_set,omitempty"`       // use the shared routes in route_sets with this name. If neither Routes nor RouteSet is given, the top-level routes are used
+
+	routes      *RouteTable `yaml:"-"`
+	ipWhitelist *IpList     `yaml:"-"`
+	ipBlacklist *IpList     `yaml:"-"`
+}
+
+// RouteTable is a list of routes, with the structures to look up the route for clients
+type RouteTable struct {
+	Name   string  // the yaml path of the routes, e.g. "routes", "route_sets.lan"
+	Routes []Route // shares the underlying array with the config
+
+	routeMatcher *RouteMatcher
+	defaultRoute *Route
+}
+
+func (t *RouteTable) routePath(i int) string {
+	return fmt.Sprintf("%s[%d]", t.Name, i)
+}
+
+// collectRouteTables returns the top-level routes first, then the routes of listeners, then route sets in name order
+func (c *Config) collectRouteTables() []*RouteTable {
+	tables := []*RouteTable{{Name: "routes", Routes: c.Routes}}
+	for i := range c.Listeners {
+		if len(c.Listeners[i].Routes) > 0 {
+			tables = append(tables, &RouteTable{Name: fmt.Sprintf("listeners[%d].routes", i), Routes: c.Listeners[i].Routes})
+		}
+	}
+	var names []string
+	for name := range c.RouteSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tables = append(tables, &RouteTable{Name: "route_sets." + name, Routes: c.RouteSets[name]})
+	}
+	return tables
+}
+
+func (c *Config) validateListeners(v *validator) {
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	if len(c.Listen) > 0 {
+		names[DefaultListenerName] = true
+		addresses[c.Listen] = true
+	}
+	usedRouteSets := make(map[string]bool)
+	topLevelRoutesUsed := len(c.Listen) > 0
+	for i := range c.Listeners {
+		listener := &c.Listeners[i]
+		path := fmt.Sprintf("listeners[%d]", i)
+		v.checkAddress(path+".listen", listener.Listen, true)
+		listener.ProxyProtocolConfig.validate(v, path+".")
+		if addresses[listener.Listen] {
+			v.errorf(path+".listen", "duplicated listen address %s", listener.Listen)
+		}
+		addresses[listener.Listen] = true
+		if names[listener.Name] {
+			v.errorf(path+".name", "duplicated listener name %s", listener.Name)
+		}
+		names[listener.Name] = true
+
+		if len(listener.Routes) > 0 && len(listener.RouteSet) > 0 {
+			v.errorf(path, "cannot specify both routes and route_set")
+		} else if len(listener.RouteSet) > 0 {
+			if _, ok := c.RouteSets[listener.RouteSet]; !ok {
+				v.errorf(path+".route_set", "unknown route set %s", listener.RouteSet)
+			}
+			usedRouteSets[listener.RouteSet] = true
+		} else if len(listener.Routes) == 0 {
+			topLevelRoutesUsed = true
+		}
+	}
+
+	if len(c.Routes) > 0 && !topLevelRoutesUsed {
+		v.warnf("routes", "not used by any listener")
+	}
+	for name := range c.RouteSets {
+		if !usedRouteSets[name] {
+			v.warnf("route_sets."+name, "not used by any listener")
+		}
+	}
+}
+
+// prepareListeners creates the listener of the top-level listen, and links the listeners with their routes and ip filters.
+// It should be called after the global ip filters are ready
+func (c *Config) prepareListeners(v *validator) {
+	tables := make(map[string]*RouteTable)
+	for _, table := range c.routeTables {
+		tables[table.Name] = table
+	}
+
+	c.listeners = nil
+	if len(c.Listen) > 0 {
+		c.listeners = append(c.listeners, &Listener{
+			Name:                DefaultListenerName,
+			Listen:              c.Listen,
+			ProxyProtocolConfig: c.ProxyProtocolConfig,
+			RealIp:              c.RealIp,
+			routes:              tables["routes"],
+			ipWhitelist:         c.ipWhitelist,
+			ipBlacklist:         c.ipBlacklist,
+		})
+	}
+	for i := range c.Listeners {
+		listener := &c.Listeners[i]
+		path := fmt.Sprintf("listeners[%d]", i)
+		listener.ipWhitelist = c.initIpList(v, path+".whitelisted_ips", listener.WhitelistedIps)
+		if listener.ipWhitelist == nil {
+			listener.ipWhitelist = c.ipWhitelist
+		}
+		listener.ipBlacklist = c.initIpList(v, path+".blacklisted_ips", listener.BlacklistedIps)
+		if listener.ipBlacklist == nil {
+			listener.ipBlacklist = c.ipBlacklist
+		}
+		listener.proxyProtocolTrusted = c.initIpList(v, path+".proxy_protocol_trusted", listener.ProxyProtocolTrusted)
+		listener.RealIp.init(v, path+".real_ip", c.IpDomainRefresh)
+
+		if len(listener.Routes) > 0 {
+			listener.routes = tables[path+".routes"]
+		} else if len(listener.RouteSet) > 0 {
+			listener.routes = tables["route_sets."+listener.RouteSet]
+		} else {
+			listener.routes = tables["routes"]
+		}
+		if listener.routes == nil {
+			listener.routes = &RouteTable{} // unknown route set, already reported in the validation
+		}
+		c.listeners = append(c.listeners, listener)
+	}
+}
+
+// gather builds the route matcher, and finds the default route
+func (t *RouteTable) gather(v *validator) {
+	t.routeMatcher = NewRouteMatcher()
+	t.defaultRoute = nil
+	for i := range t.Routes {
+		route := &t.Routes[i]
+		if route.Name == DefaultRouteName {
+			t.defaultRoute = route
+			if len(route.Matches) > 0 {
+				v.warnf(t.routePath(i)+".matches", "'matches' field for default route is useless")
+			}
+		} else {
+			for j, addr := range route.Matches {
+				overridden, err := t.routeMatcher.Add(addr, route)
+				if err != nil {
+					continue // already reported in the validation
+				}
+				if overridden != nil {
+					v.warnf(fmt.Sprintf("%s.matches[%d]", t.routePath(i), j), "duplicated route match %s, found in %s and %s, the latter one takes precedence", addr, overridden.Name, route.Name)
+				}
+			}
+		}
+	}
+}
+
+// ---------------------- getters ----------------------
+
+func (t *RouteTable) GetRouteMatcher() *RouteMatcher {
+	return t.routeMatcher
+}
+
+func (t *RouteTable) GetDefaultRoute() *Route {
+	return t.defaultRoute
+}
+
+func (l *Listener) GetRoutes() *RouteTable {
+	return l.routes
+}
+
+// GetIpFilters returns the ip whitelist and blacklist of the listener, which override the global ones.
+// If route is not nil, the lists declared in the route are returned instead, which are checked in addition to the ones of the listener.
+// Lists might be nil
+func (l *Listener) GetIpFilters(route *Route) (whitelist *IpList, blacklist *IpList) {
+	if route != nil {
+		return route.ipWhitelist, route.ipBlacklist
+	}
+	return l.ipWhitelist, l.ipBlacklist
+}
+
+// GetListeners returns all listeners. The one created by the top-level listen goes first
+func (c *Config) GetListeners() []*Listener {
+	return c.listeners
+}
+
+// GetListener returns the listener with the given name. It might return nil
+func (c *Config) GetListener(name string) *Listener {
+	for _, listener := range c.listeners {
+		if listener.Name == name {
+			return listener
+		}
+	}
+	return nil
+}
+
+// GetRouteTables returns all route lists, including the ones not used by any listener
+func (c *Config) GetRouteTables() []*RouteTable {
+	return c.routeTables
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var addressVarRegex = regexp.MustCompile(`^[A-Za-z0-9.-]*$`)
+
+// RegexMatchPrefix is the prefix of a regex match pattern, e.g. "re:^(?P<srv>[a-z0-9]+)\.mc\.example\.com$"
+const RegexMatchPrefix = "re:"
+
+type matchKind int
+
+const (
+	matchExact     matchKind = iota // "mc.example.com", matches the hostname itself
+	matchSubdomain                  // "*.example.com", matches any subdomain of example.com, but not example.com itself
+	matchDomain                     // ".example.com", matches example.com and any of its subdomains
+)
+
+type matcherEntry struct {
+	pattern string
+	route   *Route
+}
+
+type regexEntry struct {
+	regex *regexp.Regexp
+	route *Route
+}
+
+// RouteMatch is the result of a route lookup
+type RouteMatch struct {
+	Route              *Route
+	Vars               map[string]string // capture groups of the matched regex pattern, by name and by index
+	UnsupportedVersion bool              // the route is selected to disconnect the client with its UnsupportedVersionMessage
+}
+
+// Expand replaces the "${var}" placeholders in the given template with the captured values
+func (m *RouteMatch) Expand(template string) string {
+	return ExpandTemplate(template, m.Vars)
+}
+
+// ExpandAddress is like Expand, for addresses like targets. The captured values come from the client,
+// so they can only contain letters, digits, '.' and '-', otherwise the client could point the address to any host or port
+func (m *RouteMatch) ExpandAddress(template string) (string, error) {
+	for _, name := range templateVars(template) {
+		if value, ok := m.Vars[name]; ok && !addressVarRegex.MatchString(value) {
+			return "", fmt.Errorf("captured value %q of ${%s} is not a valid hostname part", value, name)
+		}
+	}
+	return m.Expand(template), nil
+}
+
+// RouteMatcher finds the route for the address in the handshake packet
+//
+// Priority, from high to low:
+//  1. patterns with port, then patterns without port
+//  2. exact hostname, then wildcard / suffix patterns
+//  3. for wildcard / suffix patterns, the one with the longest domain suffix wins
+//  4. regex patterns, in declaration order
+//
+// Regex patterns are tested after all hostname patterns, against "hostname:port" first, then against "hostname"
+type RouteMatcher struct {
+	exact     map[string][]*Route // "host" or "host:port" (lowered case) -> routes, in the order they are matched
+	subdomain map[string][]*Route // key is the "example.com" part of "*.example.com", with optional ":port"
+	domain    map[string][]*Route // key is the "example.com" part of ".example.com", with optional ":port"
+	regexes   []regexEntry        // in the order they are matched
+	entries   []matcherEntry      // in insertion order, for dumping
+}
+
+func NewRouteMatcher() *RouteMatcher {
+	return &RouteMatcher{
+		exact:     make(map[string][]*Route),
+		subdomain: make(map[string][]*Route),
+		domain:    make(map[string][]*Route),
+	}
+}
+
+func parseMatchPattern(pattern string) (matchKind, string, error) {
+	host, portSuffix := pattern, ""
+	if strings.Contains(pattern, ":") {
+		h, p, err := net.SplitHostPort(pattern)
+		if err != nil {
+			return 0, "", err
+		}
+		host, portSuffix = h, ":"+p
+	}
+	host = strings.ToLower(strings.TrimRight(host, "."))
+
+	kind := matchExact
+	if strings.HasPrefix(host, "*.") {
+		kind = matchSubdomain
+		host = host[2:]
+	} else if strings.HasPrefix(host, ".") {
+		kind = matchDomain
+		host = host[1:]
+	}
+	if len(host) == 0 {
+		return 0, "", fmt.Errorf("empty hostname in pattern %s", pattern)
+	}
+	if strings.Contains(host, "*") {
+		return 0, "", fmt.Errorf("wildcard is only allowed as the leading '*.' in pattern %s", pattern)
+	}
+	return kind, host + portSuffix, nil
+}
+
+// Add registers a match pattern for the given route.
+//
+// Routes with filters sharing the same pattern are matched in insertion order, before the routes without filters.
+// Among the routes without filters sharing the same pattern, the last added one wins, and the one it overrides is returned
+func (m *RouteMatcher) Add(pattern string, route *Route) (*Route, error) {
+	if strings.HasPrefix(pattern, RegexMatchPrefix) {
+		regex, err := compileMatchRegex(pattern)
+		if err != nil {
+			return nil, err
+		}
+		idx := len(m.regexes)
+		for i, entry := range m.regexes {
+			if entry.regex.String() == regex.String() && !entry.route.hasFilters() {
+				idx = i
+				break
+			}
+		}
+		var overridden *Route
+		if idx < len(m.regexes) && !route.hasFilters() {
+			overridden = m.regexes[idx].route
+		}
+		m.regexes = append(m.regexes[:idx], append([]regexEntry{{regex: regex, route: route}}, m.regexes[idx:]...)...)
+		m.entries = append(m.entries, matcherEntry{pattern: pattern, route: route})
+		return overridden, nil
+	}
+
+	kind, key, err := parseMatchPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var table map[string][]*Route
+	switch kind {
+	case matchSubdomain:
+		table = m.subdomain
+	case matchDomain:
+		table = m.domain
+	default:
+		table = m.exact
+	}
+
+	routes := table[key]
+	idx := len(routes)
+	for i, r := range routes {
+		if !r.hasFilters() {
+			idx = i
+			break
+		}
+	}
+	var overridden *Route
+	if idx < len(routes) && !route.hasFilters() {
+		overridden = routes[idx]
+	}
+	table[key] = append(routes[:idx], append([]*Route{route}, routes[idx:]...)...)
+	m.entries = append(m.entries, matcherEntry{pattern: pattern, route: route})
+	return overridden, nil
+}
+
+// Match returns the route with the highest priority. It might return nil
+func (m *RouteMatcher) Match(hostname string, port uint16) *RouteMatch {
+	var result *RouteMatch
+	m.walk(hostname, port, func(match *RouteMatch) bool {
+		result = match
+		return false
+	})
+	return result
+}
+
+// Candidates returns all matching routes, from the highest priority to the lowest priority.
+// Each route appears at most once
+func (m *RouteMatcher) Candidates(hostname string, port uint16) []*RouteMatch {
+	var result []*RouteMatch
+	visited := make(map[*Route]bool)
+	m.walk(hostname, port, func(match *RouteMatch) bool {
+		if !visited[match.Route] {
+			visited[match.Route] = true
+			result = append(result, match)
+		}
+		return true
+	})
+	return result
+}
+
+// walk visits matching routes in priority order, until visit returns false
+func (m *RouteMatcher) walk(hostname string, port uint16, visit func(match *RouteMatch) bool) {
+	hostname = strings.ToLower(strings.TrimRight(hostname, ".")) // domain name might have a tailing ".", remove that
+	address := fmt.Sprintf("%s:%d", hostname, port)
+	_ = m.walkHost(hostname, address[len(hostname):], visit) &&
+		m.walkHost(hostname, "", visit) &&
+		m.walkRegex(address, visit) &&
+		m.walkRegex(hostname, visit)
+}
+
+func (m *RouteMatcher) walkRegex(s string, visit func(match *RouteMatch) bool) bool {
+	for _, entry := range m.regexes {
+		groups := entry.regex.FindStringSubmatch(s)
+		if groups == nil {
+			continue
+		}
+		vars := make(map[string]string)
+		for i, name := range entry.regex.SubexpNames() {
+			vars[fmt.Sprintf("%d", i)] = groups[i]
+			if len(name) > 0 {
+				vars[name] = groups[i]
+			}
+		}
+		if !visit(&RouteMatch{Route: entry.route, Vars: vars}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *RouteMatcher) walkHost(hostname string, portSuffix string, visit func(match *RouteMatch) bool) bool {
+	visitTable := func(table map[string][]*Route, key string) bool {
+		for _, route := range table[key] {
+			if !visit(&RouteMatch{Route: route}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !visitTable(m.exact, hostname+portSuffix) || !visitTable(m.domain, hostname+portSuffix) {
+		return false
+	}
+
+	// walk through the parent domains, from the most specific one to the least specific one
+	suffix := hostname
+	for {
+		idx := strings.IndexByte(suffix, '.')
+		if idx < 0 {
+			break
+		}
+		suffix = suffix[idx+1:]
+		if !visitTable(m.subdomain, suffix+portSuffix) || !visitTable(m.domain, suffix+portSuffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func compileMatchRegex(pattern string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(strings.TrimPrefix(pattern, RegexMatchPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	return regex, nil
+}
+
+func (m *RouteMatcher) Len() int {
+	return len(m.entries)
+}
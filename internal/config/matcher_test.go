@@ -0,0 +1,230 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestMatcher(t *testing.T, patterns map[string]string) *RouteMatcher {
+	m := NewRouteMatcher()
+	for pattern, name := range patterns {
+		if _, err := m.Add(pattern, &Route{Name: name}); err != nil {
+			t.Fatalf("Failed to add pattern %s: %v", pattern, err)
+		}
+	}
+	return m
+}
+
+func checkMatch(t *testing.T, m *RouteMatcher, hostname string, port uint16, expected string) {
+	match := m.Match(hostname, port)
+	actual := ""
+	if match != nil {
+		actual = match.Route.Name
+	}
+	if actual != expected {
+		t.Errorf("Match(%s, %d) = %q, expected %q", hostname, port, actual, expected)
+	}
+}
+
+func TestMatcherExact(t *testing.T) {
+	m := newTestMatcher(t, map[string]string{
+		"mc.example.com":       "host",
+		"mc.example.com:7777":  "host_port",
+		"LOCALHOST":            "localhost",
+		"other.example.com:25": "other",
+	})
+
+	checkMatch(t, m, "mc.example.com", 7777, "host_port")
+	checkMatch(t, m, "mc.example.com", 25565, "host")
+	checkMatch(t, m, "MC.Example.COM.", 25565, "host")
+	checkMatch(t, m, "localhost", 1, "localhost")
+	checkMatch(t, m, "other.example.com", 25, "other")
+	checkMatch(t, m, "other.example.com", 26, "")
+	checkMatch(t, m, "example.com", 7777, "")
+}
+
+func TestMatcherWildcard(t *testing.T) {
+	m := newTestMatcher(t, map[string]string{
+		"*.play.example.com":   "play_wildcard",
+		".example.com":         "example_suffix",
+		"*.a.play.example.com": "a_play_wildcard",
+	})
+
+	checkMatch(t, m, "foo.play.example.com", 25565, "play_wildcard")
+	checkMatch(t, m, "foo.bar.play.example.com", 25565, "play_wildcard")
+	checkMatch(t, m, "b.a.play.example.com", 25565, "a_play_wildcard")
+	checkMatch(t, m, "a.play.example.com", 25565, "play_wildcard")
+
+	// "*." does not match the domain itself, but "." does
+	checkMatch(t, m, "play.example.com", 25565, "example_suffix")
+	checkMatch(t, m, "example.com", 25565, "example_suffix")
+	checkMatch(t, m, "www.example.com", 25565, "example_suffix")
+
+	checkMatch(t, m, "notexample.com", 25565, "")
+	checkMatch(t, m, "example.org", 25565, "")
+}
+
+func TestMatcherPriority(t *testing.T) {
+	m := newTestMatcher(t, map[string]string{
+		"mc.example.com":        "exact",
+		"*.example.com":         "wildcard",
+		"*.example.com:7777":    "wildcard_port",
+		".mc.example.com":       "suffix",
+		"foo.mc.example.com":    "foo_exact",
+		"foo.mc.example.com:25": "foo_exact_port",
+	})
+
+	// exact beats wildcard
+	checkMatch(t, m, "mc.example.com", 25565, "exact")
+	// ports beats hostname-only, no matter how specific the hostname pattern is
+	checkMatch(t, m, "mc.example.com", 7777, "wildcard_port")
+	checkMatch(t, m, "foo.mc.example.com", 7777, "wildcard_port")
+	checkMatch(t, m, "foo.mc.example.com", 25, "foo_exact_port")
+	// more specific suffix wins
+	checkMatch(t, m, "bar.mc.example.com", 25565, "suffix")
+	checkMatch(t, m, "foo.mc.example.com", 25565, "foo_exact")
+	checkMatch(t, m, "bar.example.com", 25565, "wildcard")
+}
+
+func TestMatcherRegex(t *testing.T) {
+	m := newTestMatcher(t, map[string]string{
+		`re:^(?P<srv>[a-z0-9]+)\.mc\.example\.com$`:      "regex",
+		`re:^(?P<srv>[a-z0-9]+)\.mc\.example\.com:7777$`: "regex_port",
+		"exact.mc.example.com":                           "exact",
+	})
+
+	checkMatch(t, m, "srv1.mc.example.com", 25565, "regex")
+	checkMatch(t, m, "SRV1.mc.example.com", 25565, "regex")
+	checkMatch(t, m, "srv1.mc.example.com", 7777, "regex_port")
+	checkMatch(t, m, "exact.mc.example.com", 25565, "exact")
+	checkMatch(t, m, "exact.mc.example.com", 7777, "exact") // regexes come after all hostname patterns
+	checkMatch(t, m, "a.b.mc.example.com", 25565, "")
+
+	match := m.Match("srv1.mc.example.com", 25565)
+	if match == nil {
+		t.Fatalf("No match found")
+	}
+	if match.Vars["srv"] != "srv1" || match.Vars["1"] != "srv1" || match.Vars["0"] != "srv1.mc.example.com" {
+		t.Errorf("Unexpected captured vars %v", match.Vars)
+	}
+	if s := match.Expand("${srv}.internal:25565"); s != "srv1.internal:25565" {
+		t.Errorf("Unexpected target expansion result %s", s)
+	}
+	if s := match.Expand("${srv}-${unknown}"); s != "srv1-${unknown}" {
+		t.Errorf("Unexpected expansion result with unknown var %s", s)
+	}
+}
+
+func TestMatcherExpandAddress(t *testing.T) {
+	m := newTestMatcher(t, map[string]string{
+		`re:^(.*)\.mc\.example\.com$`: "loose",
+	})
+	for hostname, expected := range map[string]string{
+		"srv-1.mc.example.com":          "srv-1.internal:25565",
+		"evil.com:1234/.mc.example.com": "",
+		"evil.com:1234#.mc.example.com": "",
+		"10.0.0.1:22 .mc.example.com":   "",
+		"a.b.c.mc.example.com":          "a.b.c.internal:25565",
+	} {
+		match := m.Match(hostname, 25565)
+		if match == nil {
+			t.Fatalf("No match found for %s", hostname)
+		}
+		actual, err := match.ExpandAddress("${1}.internal:25565")
+		if len(expected) == 0 && err == nil {
+			t.Errorf("Expanding with %q should fail, found %s", hostname, actual)
+		} else if len(expected) > 0 && (err != nil || actual != expected) {
+			t.Errorf("Expanding with %q: expected %s, found %s, %v", hostname, expected, actual, err)
+		}
+	}
+}
+
+func TestMatcherRegexPriority(t *testing.T) {
+	m := newTestMatcher(t, map[string]string{
+		`re:.*\.example\.com`: "regex",
+		"mc.example.com":      "exact",
+		"*.play.example.com":  "wildcard",
+	})
+
+	// the unanchored regex also matches "hostname:port", but specific patterns still win
+	checkMatch(t, m, "mc.example.com", 25565, "exact")
+	checkMatch(t, m, "foo.play.example.com", 25565, "wildcard")
+	checkMatch(t, m, "other.example.com", 25565, "regex")
+}
+
+func TestMatcherInvalidPattern(t *testing.T) {
+	m := NewRouteMatcher()
+	for _, pattern := range []string{"*", "*.", "foo.*.example.com", "a:b:c", "re:(unclosed"} {
+		if _, err := m.Add(pattern, &Route{}); err == nil {
+			t.Errorf("Pattern %q should be invalid", pattern)
+		}
+	}
+}
+
+func TestMatcherDuplicated(t *testing.T) {
+	m := NewRouteMatcher()
+	first := &Route{Name: "first"}
+	if existed, err := m.Add("*.example.com", first); existed != nil || err != nil {
+		t.Fatalf("Unexpected result for the first add: %v %v", existed, err)
+	}
+	if existed, err := m.Add("*.EXAMPLE.com", &Route{Name: "second"}); existed != first || err != nil {
+		t.Fatalf("Duplicated pattern is not detected: %v %v", existed, err)
+	}
+	checkMatch(t, m, "mc.example.com", 25565, "second")
+}
+
+func TestMatcherDuplicatedWithFilters(t *testing.T) {
+	m := NewRouteMatcher()
+	for _, route := range []*Route{
+		{Name: "status", NextStates: []string{"status"}},
+		{Name: "first"},
+		{Name: "second"},
+		{Name: "login", NextStates: []string{"login"}},
+	} {
+		for _, pattern := range []string{"mc.example.com", "re:^play\\.example\\.com$"} {
+			if _, err := m.Add(pattern, route); err != nil {
+				t.Fatalf("Failed to add pattern %s: %v", pattern, err)
+			}
+		}
+	}
+
+	// routes with filters are tested first in declaration order, then the last unfiltered one
+	for _, hostname := range []string{"mc.example.com", "play.example.com"} {
+		var names []string
+		for _, match := range m.Candidates(hostname, 25565) {
+			names = append(names, match.Route.Name)
+		}
+		if strings.Join(names, ",") != "status,login,second,first" {
+			t.Errorf("Unexpected candidates %v for %s", names, hostname)
+		}
+	}
+}
+
+func TestMatcherCandidates(t *testing.T) {
+	m := NewRouteMatcher()
+	status := &Route{Name: "status"}
+	login := &Route{Name: "login"}
+	wildcard := &Route{Name: "wildcard"}
+	for _, entry := range []struct {
+		pattern string
+		route   *Route
+	}{
+		{"mc.example.com", status},
+		{"mc.example.com", login},
+		{"*.example.com", wildcard},
+		{"*.example.com", status},
+	} {
+		if _, err := m.Add(entry.pattern, entry.route); err != nil {
+			t.Fatalf("Failed to add pattern %s: %v", entry.pattern, err)
+		}
+	}
+
+	var names []string
+	for _, match := range m.Candidates("mc.example.com", 25565) {
+		names = append(names, match.Route.Name)
+	}
+	if strings.Join(names, ",") != "login,status,wildcard" {
+		t.Errorf("Unexpected candidates %v", names)
+	}
+	checkMatch(t, m, "mc.example.com", 25565, "login")
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultNotAllowedMessage = `{"translate":"multiplayer.disconnect.not_whitelisted"}`
+	defaultBannedMessage     = `{"translate":"multiplayer.disconnect.banned"}`
+	bannedExpiresFormat      = "2006-01-02 15:04:05 -0700" // the time format used in vanilla banned-players.json
+)
+
+// PlayerList is a list of players, declared in the config directly, and / or loaded from a json file
+// in the vanilla whitelist.json / banned-players.json format
+type PlayerList struct {
+	Players []string `yaml:"players,omitempty"` // player names (case-insensitive) or uuids
+	File    string   `yaml:"file,omitempty"`    // optional, the json file to load players from. It's reloaded when modified
+	Message string   `yaml:"message,omitempty"` // optional, mc message sent to refused players. Supports "${name}", "${uuid}" and "${reason}"
+
+	static *playerSet      `yaml:"-"`
+	file   *playerListFile `yaml:"-"`
+}
+
+// PlayerEntry is an entry of a PlayerList
+type PlayerEntry struct {
+	Name    string         `json:"name"`
+	Uuid    *protocol.UUID `json:"-"`
+	Reason  string         `json:"reason"`  // ban reason, only in banned-players.json
+	Expires string         `json:"expires"` // ban expire time, only in banned-players.json. "forever" or in bannedExpiresFormat
+
+	RawUuid string `json:"uuid"`
+
+	expiresAt time.Time // zero value for never
+}
+
+type playerSet struct {
+	names map[string]*PlayerEntry // lowered case name -> entry
+	uuids map[protocol.UUID]*PlayerEntry
+	size  int
+}
+
+type playerListFile struct {
+	path    string
+	mutex   sync.Mutex
+	modTime time.Time
+	players *playerSet
+}
+
+func newPlayerSet(entries []*PlayerEntry) *playerSet {
+	s := &playerSet{
+		names: make(map[string]*PlayerEntry),
+		uuids: make(map[protocol.UUID]*PlayerEntry),
+		size:  len(entries),
+	}
+	for _, entry := range entries {
+		if len(entry.Name) > 0 {
+			s.names[strings.ToLower(entry.Name)] = entry
+		}
+		if entry.Uuid != nil {
+			s.uuids[*entry.Uuid] = entry
+		}
+	}
+	return s
+}
+
+func (e *PlayerEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// find returns the unexpired entry with the uuid or the name
+func (s *playerSet) find(name string, uuid *protocol.UUID, now time.Time) *PlayerEntry {
+	if uuid != nil {
+		if entry, ok := s.uuids[*uuid]; ok && !entry.expired(now) {
+			return entry
+		}
+	}
+	if entry, ok := s.names[strings.ToLower(name)]; ok && !entry.expired(now) {
+		return entry
+	}
+	return nil
+}
+
+// findByName returns the unexpired entry with the name. If both the entry and the client have the uuid, they must be equal
+func (s *playerSet) findByName(name string, uuid *protocol.UUID, now time.Time) *PlayerEntry {
+	entry, ok := s.names[strings.ToLower(name)]
+	if !ok || entry.expired(now) {
+		return nil
+	}
+	if entry.Uuid != nil && uuid != nil && *entry.Uuid != *uuid {
+		return nil
+	}
+	return entry
+}
+
+func loadPlayerListFile(path string) (*playerSet, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*PlayerEntry
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse player list file %s: %v", path, err)
+	}
+	for i, entry := range entries {
+		if len(entry.RawUuid) > 0 {
+			uuid, err := protocol.ParseUUID(entry.RawUuid)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d of player list file %s: %v", i, path, err)
+			}
+			entry.Uuid = &uuid
+		}
+		if len(entry.Expires) > 0 && entry.Expires != "forever" {
+			if entry.expiresAt, err = time.Parse(bannedExpiresFormat, entry.Expires); err != nil {
+				return nil, fmt.Errorf("entry %d of player list file %s has invalid expire time %s: %v", i, path, entry.Expires, err)
+			}
+		}
+	}
+	return newPlayerSet(entries), nil
+}
+
+// get returns the loaded players, and reloads the file if it's modified since the last load.
+// If the reload failed, the previously loaded players are used
+func (f *playerListFile) get() *playerSet {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		log.Warnf("Failed to stat player list file %s: %v", f.path, err)
+		return f.players
+	}
+	if !stat.ModTime().Equal(f.modTime) {
+		players, err := loadPlayerListFile(f.path)
+		if err != nil {
+			log.Warnf("Failed to reload player list file %s, keep using the previous one: %v", f.path, err)
+		} else {
+			log.Infof("Reloaded player list file %s with %d players", f.path, players.size)
+			f.players = players
+		}
+		f.modTime = stat.ModTime()
+	}
+	return f.players
+}
+
+func (l *PlayerList) init() error {
+	var entries []*PlayerEntry
+	for _, player := range l.Players {
+		if uuid, err := protocol.ParseUUID(player); err == nil {
+			entries = append(entries, &PlayerEntry{Uuid: &uuid, RawUuid: uuid.String()})
+		} else {
+			entries = append(entries, &PlayerEntry{Name: player})
+		}
+	}
+	l.static = newPlayerSet(entries)
+
+	if len(l.File) > 0 {
+		stat, err := os.Stat(l.File)
+		if err != nil {
+			return err
+		}
+		players, err := loadPlayerListFile(l.File)
+		if err != nil {
+			return err
+		}
+		l.file = &playerListFile{path: l.File, modTime: stat.ModTime(), players: players}
+	}
+	return nil
+}
+
+func (l *PlayerList) lookup(find func(s *playerSet) *PlayerEntry) *PlayerEntry {
+	if entry := find(l.static); entry != nil {
+		return entry
+	}
+	if l.file != nil {
+		return find(l.file.get())
+	}
+	return nil
+}
+
+// Find returns the entry matching the name or the uuid of the player, or nil if the player is not in the list.
+// Expired entries are ignored
+func (l *PlayerList) Find(name string, uuid *protocol.UUID) *PlayerEntry {
+	now := time.Now()
+	return l.lookup(func(s *playerSet) *PlayerEntry {
+		return s.find(name, uuid, now)
+	})
+}
+
+// FindByName is like Find, but the entry must match the name of the player, and the uuid too if both have one.
+// The uuid in the Login Start packet is claimed by the client, so it alone never proves who the player is
+func (l *PlayerList) FindByName(name string, uuid *protocol.UUID) *PlayerEntry {
+	now := time.Now()
+	return l.lookup(func(s *playerSet) *PlayerEntry {
+		return s.findByName(name, uuid, now)
+	})
+}
+
+// hasUuidOnlyPlayers returns if any of the players declared in the config is a uuid
+func (l *PlayerList) hasUuidOnlyPlayers() bool {
+	for _, entry := range l.static.uuids {
+		if len(entry.Name) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *PlayerList) formatMessageJson(defaultMessage string, name string, uuid *protocol.UUID, entry *PlayerEntry) string {
+	vars := map[string]string{"name": name}
+	if uuid != nil {
+		vars["uuid"] = uuid.String()
+	}
+	if entry != nil {
+		vars["reason"] = entry.Reason
+	}
+	if len(l.Message) == 0 {
+		if entry != nil && len(entry.Reason) > 0 {
+			b, _ := json.Marshal(entry.Reason)
+			return fmt.Sprintf(`{"translate":"multiplayer.disconnect.banned.reason","with":[%s]}`, b)
+		}
+		return defaultMessage
+	}
+	return formatMessageTemplateJson(l.Message, vars)
+}
+
+// CheckPlayer checks if the player is refused by the given lists. Nil lists are ignored.
+// If the player is refused, the json of the disconnect message is also returned
+func CheckPlayer(allowed *PlayerList, banned *PlayerList, name string, uuid *protocol.UUID) (refused bool, messageJson string) {
+	if banned != nil {
+		if entry := banned.Find(name, uuid); entry != nil {
+			return true, banned.formatMessageJson(defaultBannedMessage, name, uuid, entry)
+		}
+	}
+	if allowed != nil {
+		if entry := allowed.FindByName(name, uuid); entry == nil {
+			return true, allowed.formatMessageJson(defaultNotAllowedMessage, name, uuid, nil)
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+)
+
+func TestPlayerListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned-players.json")
+	writeFile := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to change file time: %v", err)
+		}
+	}
+	writeFile(`[
+		{"uuid": "069a79f4-44e9-4726-a5be-fca90e38aaf5", "name": "Notch", "created": "2024-01-01 00:00:00 +0000", "source": "Server", "expires": "forever", "reason": "Griefing \"spawn\""},
+		{"uuid": "853c80ef-3c37-49fd-aa49-938b674adae6", "name": "jeb_", "created": "2024-01-01 00:00:00 +0000", "source": "Server", "expires": "2000-01-01 00:00:00 +0000", "reason": "Expired"}
+	]`, time.Now().Add(-time.Hour))
+
+	list := &PlayerList{Players: []string{"Steve"}, File: path, Message: `{"text": "Banned: ${reason}"}`}
+	if err := list.init(); err != nil {
+		t.Fatalf("Failed to init player list: %v", err)
+	}
+
+	notchUuid, _ := protocol.ParseUUID("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+	if entry := list.Find("someone", &notchUuid); entry == nil || entry.Name != "Notch" {
+		t.Errorf("Player should be found by uuid, found %+v", entry)
+	}
+	if entry := list.Find("STEVE", nil); entry == nil {
+		t.Errorf("Player should be found by name case-insensitively")
+	}
+	if entry := list.Find("jeb_", nil); entry != nil {
+		t.Errorf("Expired entry should be ignored, found %+v", entry)
+	}
+
+	refused, messageJson := CheckPlayer(nil, list, "Notch", nil)
+	if !refused || messageJson != `{"text": "Banned: Griefing \"spawn\""}` {
+		t.Errorf("Unexpected check result %v %s", refused, messageJson)
+	}
+
+	// the file is reloaded after modified
+	writeFile(`[{"uuid": "853c80ef-3c37-49fd-aa49-938b674adae6", "name": "jeb_", "expires": "forever", "reason": "Again"}]`, time.Now())
+	if entry := list.Find("Notch", nil); entry != nil {
+		t.Errorf("Removed player should not be found, found %+v", entry)
+	}
+	if entry := list.Find("jeb_", nil); entry == nil || entry.Reason != "Again" {
+		t.Errorf("Added player should be found, found %+v", entry)
+	}
+
+	// a broken file keeps the previous entries
+	writeFile(`[{`, time.Now().Add(time.Hour))
+	if entry := list.Find("jeb_", nil); entry == nil {
+		t.Errorf("Previous entries should be kept if the file is broken")
+	}
+}
+
+func TestCheckPlayerAllowed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whitelist.json")
+	if err := os.WriteFile(path, []byte(`[{"uuid": "853c80ef-3c37-49fd-aa49-938b674adae6", "name": "jeb_"}]`), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	allowed := &PlayerList{Players: []string{"Steve", "069a79f4-44e9-4726-a5be-fca90e38aaf5"}, File: path, Message: "Sorry ${name}"}
+	if err := allowed.init(); err != nil {
+		t.Fatalf("Failed to init player list: %v", err)
+	}
+	jebUuid, _ := protocol.ParseUUID("853c80ef3c3749fdaa49938b674adae6")
+	notchUuid, _ := protocol.ParseUUID("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+
+	if refused, _ := CheckPlayer(allowed, nil, "steve", nil); refused {
+		t.Errorf("Allowed player is refused")
+	}
+	if refused, _ := CheckPlayer(allowed, nil, "jeb_", &jebUuid); refused {
+		t.Errorf("Allowed player with matching uuid is refused")
+	}
+	if refused, _ := CheckPlayer(allowed, nil, "jeb_", nil); refused {
+		t.Errorf("Allowed player without uuid is refused")
+	}
+	if refused, messageJson := CheckPlayer(allowed, nil, "Alex", nil); !refused || messageJson != `"Sorry Alex"` {
+		t.Errorf("Unexpected check result %v %s", refused, messageJson)
+	}
+
+	// the uuid is claimed by the client, so it never allows a player alone
+	if refused, _ := CheckPlayer(allowed, nil, "Alex", &jebUuid); !refused {
+		t.Errorf("Player with a spoofed uuid and a non-listed name is allowed")
+	}
+	if refused, _ := CheckPlayer(allowed, nil, "Alex", &notchUuid); !refused {
+		t.Errorf("Player with a uuid declared in the config is allowed without a listed name")
+	}
+	if refused, _ := CheckPlayer(allowed, nil, "jeb_", &notchUuid); !refused {
+		t.Errorf("Player with a listed name but a different uuid is allowed")
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"net"
+	"time"
+)
+
+// ProxyProtocolPolicy is how a listener treats the proxy protocol header from an upstream
+type ProxyProtocolPolicy string
+
+const (
+	ProxyProtocolRequire ProxyProtocolPolicy = "require" // use the address in the header, reject connections without the header
+	ProxyProtocolUse     ProxyProtocolPolicy = "use"     // use the address in the header if present
+	ProxyProtocolIgnore  ProxyProtocolPolicy = "ignore"  // discard the header if present, and use the tcp remote address
+	ProxyProtocolReject  ProxyProtocolPolicy = "reject"  // reject connections with the header
+)
+
+// ProxyProtocolConfig is how a listener reads the proxy protocol header sent to SMCR
+type ProxyProtocolConfig struct {
+	ProxyProtocol                bool                `yaml:"proxy_protocol,omitempty"`                  // if clients can send proxy protocol header to smcr. if true, PP header will be required from trusted upstreams
+	ProxyProtocolTrusted         []string            `yaml:"proxy_protocol_trusted,omitempty"`          // optional, ips / CIDR blocks / domains of trusted upstreams. All upstreams are trusted if not given
+	ProxyProtocolTrustedPolicy   ProxyProtocolPolicy `yaml:"proxy_protocol_trusted_policy,omitempty"`   // optional, "require" (default) or "use"
+	ProxyProtocolUntrustedPolicy ProxyProtocolPolicy `yaml:"proxy_protocol_untrusted_policy,omitempty"` // optional, "ignore" (default) or "reject"
+	ProxyProtocolHeaderTimeout   time.Duration       `yaml:"proxy_protocol_header_timeout,omitempty"`   // optional, default 10s. How long to wait for the header
+
+	proxyProtocolTrusted *IpList `yaml:"-"`
+}
+
+func (p *ProxyProtocolConfig) fillDefaults() {
+	if len(p.ProxyProtocolTrustedPolicy) == 0 {
+		p.ProxyProtocolTrustedPolicy = ProxyProtocolRequire
+	}
+	if len(p.ProxyProtocolUntrustedPolicy) == 0 {
+		p.ProxyProtocolUntrustedPolicy = ProxyProtocolIgnore
+	}
+	if p.ProxyProtocolHeaderTimeout <= 0 {
+		p.ProxyProtocolHeaderTimeout = 10 * time.Second
+	}
+}
+
+// validate checks the fields. pathPrefix is the yaml path of the object containing the fields, with a tailing "."
+func (p *ProxyProtocolConfig) validate(v *validator, pathPrefix string) {
+	switch p.ProxyProtocolTrustedPolicy {
+	case ProxyProtocolRequire, ProxyProtocolUse:
+		// ok
+	default:
+		v.errorf(pathPrefix+"proxy_protocol_trusted_policy", "unknown policy %s, should be require or use", p.ProxyProtocolTrustedPolicy)
+	}
+	switch p.ProxyProtocolUntrustedPolicy {
+	case ProxyProtocolIgnore, ProxyProtocolReject:
+		// ok
+	default:
+		v.errorf(pathPrefix+"proxy_protocol_untrusted_policy", "unknown policy %s, should be ignore or reject", p.ProxyProtocolUntrustedPolicy)
+	}
+	if !p.ProxyProtocol && len(p.ProxyProtocolTrusted) > 0 {
+		v.warnf(pathPrefix+"proxy_protocol_trusted", "useless since proxy_protocol is not enabled")
+	}
+}
+
+// GetUpstreamPolicy returns the policy for the upstream with the given ip
+func (p *ProxyProtocolConfig) GetUpstreamPolicy(ip net.IP) ProxyProtocolPolicy {
+	if p.proxyProtocolTrusted == nil || (ip != nil && p.proxyProtocolTrusted.Contains(ip)) {
+		return p.ProxyProtocolTrustedPolicy
+	}
+	return p.ProxyProtocolUntrustedPolicy
+}
+
+// ProxyProtocolTlvs is the extra TLVs sent to the target server in the proxy protocol v2 header
+type ProxyProtocolTlvs struct {
+	Authority     bool  `yaml:"authority,omitempty"`       // send PP2_TYPE_AUTHORITY with the hostname in the client handshake
+	UniqueId      bool  `yaml:"unique_id,omitempty"`       // send PP2_TYPE_UNIQUE_ID with a random id of the connection
+	RouteNameType uint8 `yaml:"route_name_type,omitempty"` // if given, send a custom TLV of this type (0xE0 ~ 0xEF) with the route name
+}
+
+const (
+	minCustomTlvType = 0xE0
+	maxCustomTlvType = 0xEF
+)
+
+func (t *ProxyProtocolTlvs) validate(v *validator, path string, version int) {
+	if version != 2 {
+		v.errorf(path, "requires proxy_protocol version 2")
+	}
+	if t.RouteNameType != 0 && !(minCustomTlvType <= t.RouteNameType && t.RouteNameType <= maxCustomTlvType) {
+		v.errorf(path+".route_name_type", "invalid custom TLV type 0x%02X, should be within 0xE0 ~ 0xEF", t.RouteNameType)
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RealIpSeparator separates the fields that upstream proxies like TCPShield encode into the handshake hostname
+const RealIpSeparator = "///"
+
+// ErrNoRealIp means the handshake hostname does not contain the encoded real ip
+var ErrNoRealIp = errors.New("no real ip in the hostname")
+
+// RealIp is how a listener decodes the real client ip, that upstream proxies like TCPShield encode into the handshake hostname,
+// in the format "hostname///ip:port///timestamp" or "hostname///ip:port///timestamp///signature"
+type RealIp struct {
+	Enabled       bool          `yaml:"enabled"`
+	Required      bool          `yaml:"required,omitempty"`        // if true, reject clients without the real ip in the hostname
+	Trusted       []string      `yaml:"trusted,omitempty"`         // ips / CIDR blocks / domains of the upstream proxies. Required if PublicKeyFile is not given
+	PublicKeyFile string        `yaml:"public_key_file,omitempty"` // optional, the PEM public key to verify the signature with. If given, the signature is required
+	MaxClockSkew  time.Duration `yaml:"max_clock_skew,omitempty"`  // optional, default 5s. How much the signed timestamp can differ from now
+
+	trusted   *IpList          `yaml:"-"`
+	publicKey crypto.PublicKey `yaml:"-"`
+}
+
+// RealIpData is the data decoded from the handshake hostname
+type RealIpData struct {
+	Hostname   string       // the original hostname, without the encoded data
+	ClientAddr *net.TCPAddr // the real address of the client
+	Timestamp  time.Time
+}
+
+func (r *RealIp) init(v *validator, path string, ipDomainRefresh time.Duration) {
+	if r.MaxClockSkew <= 0 {
+		r.MaxClockSkew = 5 * time.Second
+	}
+	r.trusted = nil
+	r.publicKey = nil
+	if !r.Enabled {
+		return
+	}
+	if len(r.Trusted) > 0 {
+		trusted, err := newIpList(r.Trusted, ipDomainRefresh)
+		if err != nil {
+			v.errorf(path+".trusted", "%v", err)
+		} else {
+			r.trusted = trusted
+		}
+	} else if len(r.PublicKeyFile) == 0 {
+		v.errorf(path+".trusted", "required if public_key_file is not given, otherwise any client can fake its ip")
+	}
+	if len(r.PublicKeyFile) > 0 {
+		publicKey, err := loadPublicKey(r.PublicKeyFile)
+		if err != nil {
+			v.errorf(path+".public_key_file", "failed to load public key: %v", err)
+		} else {
+			r.publicKey = publicKey
+		}
+	}
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	der := buf
+	if block, _ := pem.Decode(buf); block != nil {
+		der = block.Bytes
+	} else if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf))); err == nil {
+		der = decoded // base64 without the PEM armor
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch publicKey.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T, should be ECDSA or RSA", publicKey)
+	}
+}
+
+// Trusts checks if the real ip data sent by the given peer should be decoded, where peerIp is the ip of the tcp connection.
+// Without the trusted list, all peers are trusted, since the signature is verified
+func (r *RealIp) Trusts(peerIp net.IP) bool {
+	if !r.Enabled {
+		return false
+	}
+	if r.trusted == nil {
+		return r.publicKey != nil
+	}
+	return peerIp != nil && r.trusted.Contains(peerIp)
+}
+
+// Decode decodes the real ip data in the hostname, and verifies the signature if a public key is given.
+// It returns ErrNoRealIp if the hostname does not contain the data
+func (r *RealIp) Decode(hostname string, now time.Time) (*RealIpData, error) {
+	if !strings.Contains(hostname, RealIpSeparator) {
+		return nil, ErrNoRealIp
+	}
+	parts := strings.SplitN(hostname, RealIpSeparator, 4)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("expected at least 3 fields, found %d", len(parts))
+	}
+
+	data := &RealIpData{Hostname: parts[0]}
+	host, portStr, err := net.SplitHostPort(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid client address %q: %v", parts[1], err)
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid client address %q", parts[1])
+	}
+	data.ClientAddr = &net.TCPAddr{IP: ip, Port: int(port)}
+	timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q", parts[2])
+	}
+	data.Timestamp = time.Unix(timestamp, 0)
+
+	if r.publicKey != nil {
+		if len(parts) < 4 {
+			return nil, fmt.Errorf("signature is missing")
+		}
+		signature, err := base64.StdEncoding.DecodeString(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature encoding: %v", err)
+		}
+		signedPayload := strings.Join(parts[:3], RealIpSeparator)
+		if !verifySignature(r.publicKey, []byte(signedPayload), signature) {
+			return nil, fmt.Errorf("signature verification failed")
+		}
+		if skew := now.Sub(data.Timestamp); skew > r.MaxClockSkew || skew < -r.MaxClockSkew {
+			return nil, fmt.Errorf("timestamp %d is %s away from now", timestamp, skew)
+		}
+	}
+	return data, nil
+}
+
+// verifySignature verifies the SHA512withECDSA or SHA512withRSA signature of the message
+func verifySignature(publicKey crypto.PublicKey, message []byte, signature []byte) bool {
+	digest := sha512.Sum512(message)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA512, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRealIpDecode(t *testing.T) {
+	v := &validator{}
+	realIp := RealIp{Enabled: true, Trusted: []string{"10.0.0.0/8"}}
+	realIp.init(v, "real_ip", time.Minute)
+	if len(v.errors) > 0 {
+		t.Fatalf("Failed to init: %v", v.errors)
+	}
+
+	if _, err := realIp.Decode("mc.example.com", time.Now()); !errors.Is(err, ErrNoRealIp) {
+		t.Errorf("Expected ErrNoRealIp, found %v", err)
+	}
+	data, err := realIp.Decode("mc.example.com///[2001:db8::1]:5555///1700000000", time.Now())
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if data.Hostname != "mc.example.com" || data.ClientAddr.String() != "[2001:db8::1]:5555" || data.Timestamp.Unix() != 1700000000 {
+		t.Errorf("Unexpected decoded data %+v", data)
+	}
+	for _, hostname := range []string{"mc.example.com///1.2.3.4:5555", "mc.example.com///1.2.3.4///1700000000", "mc.example.com///1.2.3.4:5555///now"} {
+		if _, err := realIp.Decode(hostname, time.Now()); err == nil || errors.Is(err, ErrNoRealIp) {
+			t.Errorf("Hostname %q should be invalid, found %v", hostname, err)
+		}
+	}
+}
+
+func TestRealIpTrusted(t *testing.T) {
+	v := &validator{}
+	realIp := RealIp{Enabled: true, Trusted: []string{"10.0.0.0/8", "2001:db8::1"}}
+	realIp.init(v, "real_ip", time.Minute)
+	if len(v.errors) > 0 {
+		t.Fatalf("Failed to init: %v", v.errors)
+	}
+	for ip, expected := range map[string]bool{
+		"10.1.2.3":        true,
+		"::ffff:10.1.2.3": true,
+		"2001:db8::1":     true,
+		"6.6.6.6":         false,
+		"2001:db8::2":     false,
+	} {
+		if actual := realIp.Trusts(net.ParseIP(ip)); actual != expected {
+			t.Errorf("Trusts(%s) = %v, expected %v", ip, actual, expected)
+		}
+	}
+	if realIp.Trusts(nil) {
+		t.Errorf("Unknown peers should not be trusted")
+	}
+
+	// without the trusted list and the signature, any client could fake its ip
+	v = &validator{}
+	realIp = RealIp{Enabled: true}
+	realIp.init(v, "real_ip", time.Minute)
+	if len(v.errors) != 1 || !strings.HasPrefix(v.errors[0], "real_ip.trusted: ") {
+		t.Errorf("Unexpected errors %v", v.errors)
+	}
+	if realIp.Trusts(net.ParseIP("10.1.2.3")) {
+		t.Errorf("No peer should be trusted without the trusted list and the signature")
+	}
+}
+
+func TestRealIpSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing_pub.key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	v := &validator{}
+	realIp := RealIp{Enabled: true, PublicKeyFile: keyFile}
+	realIp.init(v, "real_ip", time.Minute)
+	if len(v.errors) > 0 {
+		t.Fatalf("Failed to init: %v", v.errors)
+	}
+	if !realIp.Trusts(net.ParseIP("1.2.3.4")) {
+		t.Errorf("All peers should be trusted if the signature is verified")
+	}
+
+	now := time.Now()
+	sign := func(payload string) string {
+		digest := sha512.Sum512([]byte(payload))
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		return payload + RealIpSeparator + base64.StdEncoding.EncodeToString(signature)
+	}
+	payload := fmt.Sprintf("mc.example.com///1.2.3.4:5555///%d", now.Unix())
+	signed := sign(payload)
+
+	if data, err := realIp.Decode(signed, now); err != nil || data.ClientAddr.String() != "1.2.3.4:5555" {
+		t.Errorf("Signed hostname should be valid, found %+v, %v", data, err)
+	}
+	if _, err := realIp.Decode(payload, now); err == nil {
+		t.Errorf("Hostname without signature should be invalid")
+	}
+	if _, err := realIp.Decode(strings.Replace(signed, "1.2.3.4", "6.6.6.6", 1), now); err == nil {
+		t.Errorf("Tampered hostname should be invalid")
+	}
+	if _, err := realIp.Decode(signed, now.Add(time.Minute)); err == nil {
+		t.Errorf("Expired hostname should be invalid")
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+var templateVarRegex = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)}`)
+
+// ExpandTemplate replaces "${name}" placeholders in the template with the given vars.
+// Placeholders with unknown names are kept as-is
+func ExpandTemplate(template string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return template
+	}
+	return templateVarRegex.ReplaceAllStringFunc(template, func(s string) string {
+		name := templateVarRegex.FindStringSubmatch(s)[1]
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return s
+	})
+}
+
+// templateVars returns the names of all placeholders in the template
+func templateVars(template string) []string {
+	var names []string
+	for _, groups := range templateVarRegex.FindAllStringSubmatch(template, -1) {
+		names = append(names, groups[1])
+	}
+	return names
+}
+
+// formatMessageTemplateJson expands the mc message template, and returns its json.
+// If the template is a json, the var values are escaped as json strings
+func formatMessageTemplateJson(template string, vars map[string]string) string {
+	if json.Unmarshal([]byte(template), &json.RawMessage{}) == nil {
+		escapedVars := make(map[string]string)
+		for name, value := range vars {
+			b, _ := json.Marshal(value)
+			escapedVars[name] = string(b[1 : len(b)-1])
+		}
+		return ExpandTemplate(template, escapedVars)
+	}
+	return formatMessageJson(ExpandTemplate(template, vars))
+}
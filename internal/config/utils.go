@@ -1,30 +1,122 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/Fallen-Breath/smcr/internal/logging"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
 const envVarConfigContent = "SMCR_CONFIG"
 
-func LoadConfigOrDie(configPath string) *Config {
+// ConfigSource is where the config is loaded from. It's decided once on startup, so reloads read the same source
+type ConfigSource struct {
+	path    string
+	fromEnv bool
+}
+
+// NewConfigSource creates the config source. The config content in the environment variable takes precedence over the config file
+func NewConfigSource(configPath string) *ConfigSource {
+	_, fromEnv := os.LookupEnv(envVarConfigContent)
+	return &ConfigSource{path: configPath, fromEnv: fromEnv}
+}
+
+// IsFile returns if the config is loaded from the config file, instead of the environment variable
+func (s *ConfigSource) IsFile() bool {
+	return !s.fromEnv
+}
+
+func (s *ConfigSource) String() string {
+	if s.fromEnv {
+		return "envvar " + envVarConfigContent
+	}
+	return "config file " + s.path
+}
+
+// Load reads and initializes the config from the source. Warnings are returned even if the config is valid
+func (s *ConfigSource) Load() (*Config, []string, error) {
+	return s.load(nil)
+}
+
+// load is Load with a callback, that's called with the parsed config before it's initialized
+func (s *ConfigSource) load(onParsed func(config *Config)) (*Config, []string, error) {
 	var configBuf []byte
-	if configData, ok := os.LookupEnv(envVarConfigContent); ok {
-		log.Infof("Loading config from envvar %s", envVarConfigContent)
-		configBuf = []byte(configData)
+	if s.fromEnv {
+		configBuf = []byte(os.Getenv(envVarConfigContent))
 	} else {
-		buf, err := os.ReadFile(configPath)
+		buf, err := os.ReadFile(s.path)
 		if err != nil {
-			log.Fatalf("Failed to read config file %s: %v", configPath, err)
+			return nil, nil, fmt.Errorf("failed to read %s: %v", s, err)
 		}
 		configBuf = buf
 	}
 
 	config := Config{}
 	if err := yaml.Unmarshal(configBuf, &config); err != nil {
-		log.Fatalf("Failed to parse yaml from config file %s: %v", configPath, err)
+		return nil, nil, fmt.Errorf("failed to parse yaml from %s: %v", s, err)
+	}
+	if onParsed != nil {
+		onParsed(&config)
+	}
+	if s.fromEnv {
+		log.Infof("Loading config from %s", s)
+	}
+	warnings, err := config.Init()
+	if err != nil {
+		return nil, warnings, err
+	}
+	return &config, warnings, nil
+}
+
+// LoadConfigOrDie loads the config on startup. The log format of the config is applied
+// before logging anything, so the config warnings and errors are in that format too
+func LoadConfigOrDie(source *ConfigSource) *Config {
+	config, warnings, err := source.load(func(config *Config) {
+		logging.SetFormat(config.LogFormat)
+	})
+	for _, warning := range warnings {
+		log.Warnf("Config warning: %s", warning)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	return config
+}
+
+var pngMagic = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// loadFavicon reads the png image file, and returns it as a data uri that can be used in status responses
+func loadFavicon(path string) (string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(buf, pngMagic) {
+		return "", fmt.Errorf("file %s is not a png image", path)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// WatchConfigFile polls the modification time of the config file, and calls onChange when it's modified. It never returns
+func WatchConfigFile(configPath string, interval time.Duration, onChange func()) {
+	var lastModTime time.Time
+	if stat, err := os.Stat(configPath); err == nil {
+		lastModTime = stat.ModTime()
+	}
+	for {
+		time.Sleep(interval)
+		stat, err := os.Stat(configPath)
+		if err != nil {
+			log.Debugf("Failed to stat config file %s: %v", configPath, err)
+			continue
+		}
+		if !stat.ModTime().Equal(lastModTime) {
+			lastModTime = stat.ModTime()
+			onChange()
+		}
 	}
-	config.Init()
-	return &config
 }
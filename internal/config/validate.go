@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ValidationError lists all problems found in the config
+type ValidationError struct {
+	Problems []string // each one is prefixed with the yaml path of the field, e.g. "routes[2].target: ..."
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0]
+	}
+	return fmt.Sprintf("%d problems found in the config:\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// validator collects errors and warnings during Config.Init
+type validator struct {
+	errors   []string
+	warnings []string
+}
+
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) warnf(path string, format string, args ...interface{}) {
+	v.warnings = append(v.warnings, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.errors}
+}
+
+func (v *validator) checkAddress(path string, address string, mustWithPort bool) {
+	if len(address) == 0 {
+		v.errorf(path, "field is empty")
+		return
+	}
+
+	addrToTest := address
+	if !mustWithPort && !strings.Contains(address, ":") {
+		addrToTest = address + ":25565"
+	}
+
+	if _, _, err := net.SplitHostPort(addrToTest); err != nil {
+		v.errorf(path, "%s is not a valid address: %v", address, err)
+	}
+}
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeeeeeeeeeeeeeee\n", "ffff\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// the oversized line gets a file on its own, and the oldest file is removed
+	for name, expected := range map[string]string{
+		path:        "ffff\n",
+		path + ".1": "eeeeeeeeeeeeeeee\n",
+		path + ".2": "cccc\ndddd\n",
+	} {
+		buf, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(buf) != expected {
+			t.Errorf("Unexpected content of %s: %q, expected %q", name, buf, expected)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Too many backups are kept")
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Errorf("Write after close should fail")
+	}
+}
@@ -3,9 +3,15 @@ package logging
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"runtime"
 	"strings"
 )
 
+const (
+	FormatText = "text" // human-readable lines, the default
+	FormatJson = "json" // one json object per line, with all logrus fields
+)
+
 type MyFormatter struct {
 }
 
@@ -46,7 +52,27 @@ func (m MyFormatter) Format(entry *log.Entry) ([]byte, error) {
 
 var _ log.Formatter = &MyFormatter{}
 
+func newJsonFormatter() *log.JSONFormatter {
+	return &log.JSONFormatter{
+		TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		CallerPrettyfier: func(frame *runtime.Frame) (function string, file string) {
+			splits := strings.Split(frame.Function, ".")
+			fileSplits := strings.Split(frame.File, "/")
+			return splits[len(splits)-1], fmt.Sprintf("%s:%d", fileSplits[len(fileSplits)-1], frame.Line)
+		},
+	}
+}
+
+// SetFormat switches the log format. Unknown formats fall back to FormatText
+func SetFormat(format string) {
+	if format == FormatJson {
+		log.SetFormatter(newJsonFormatter())
+	} else {
+		log.SetFormatter(MyFormatter{})
+	}
+}
+
 func InitLog() {
 	log.StandardLogger().ReportCaller = true
-	log.SetFormatter(MyFormatter{})
+	SetFormat(FormatText)
 }
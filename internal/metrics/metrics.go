@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics, and renders them in the Prometheus text exposition format
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in the Prometheus text format
+func (r *Registry) Write(writer io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mutex.Unlock()
+
+	w := bufio.NewWriter(writer)
+	for _, m := range metrics {
+		m.write(w)
+	}
+	return w.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+// desc is the common part of all metric types
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer, typ string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", d.name, typ)
+}
+
+// seriesKey joins the label values into a map key
+func (d *desc) seriesKey(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\x00")
+}
+
+// formatLabels returns the "{a="1",b="2"}" part of a series. extra is appended as-is, e.g. `le="0.5"`
+func (d *desc) formatLabels(key string, extra string) string {
+	var parts []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\x00") {
+			parts = append(parts, fmt.Sprintf(`%s="%s"`, d.labels[i], escapeLabelValue(value)))
+		}
+	}
+	if len(extra) > 0 {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+	key := c.seriesKey(labelValues)
+	c.mutex.Lock()
+	c.values[key] += delta
+	c.mutex.Unlock()
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w, "counter")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.formatLabels(key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{desc: desc{name: name, help: help, labels: labels}, values: make(map[string]float64)}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	key := g.seriesKey(labelValues)
+	g.mutex.Lock()
+	g.values[key] += delta
+	g.mutex.Unlock()
+}
+
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, key := range sortedKeys(g.values) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", g.name, g.formatLabels(key, ""), formatFloat(g.values[key]))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	desc
+	buckets []float64 // upper bounds, sorted, with +Inf as the last one
+	mutex   sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64 // non-cumulative count of each bucket
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append(append([]float64{}, buckets...), math.Inf(1))
+	sort.Float64s(buckets)
+	h := &HistogramVec{desc: desc{name: name, help: help, labels: labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := h.seriesKey(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	value.counts[sort.SearchFloat64s(h.buckets, v)]++
+	value.sum += v
+	value.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w, "histogram")
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += value.counts[i]
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(key, fmt.Sprintf(`le="%s"`, formatFloat(upperBound))), cumulative)
+		}
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(key, ""), formatFloat(value.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.formatLabels(key, ""), value.count)
+	}
+}
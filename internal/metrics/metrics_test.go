@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryFormat(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("test_total", "A test counter", "route", "action")
+	gauge := r.NewGaugeVec("test_active", "A test gauge", "route")
+	histogram := r.NewHistogramVec("test_seconds", "A test histogram", []float64{0.5, 0.1}, "target")
+
+	counter.Inc("b", "forward")
+	counter.Add(2, "a\"\\", "reject")
+	gauge.Inc("a")
+	gauge.Inc("a")
+	gauge.Dec("a")
+	histogram.Observe(0.05, "t")
+	histogram.Observe(0.3, "t")
+	histogram.Observe(7, "t")
+
+	buf := &bytes.Buffer{}
+	if err := r.Write(buf); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	expected := `# HELP test_total A test counter
+# TYPE test_total counter
+test_total{route="a\"\\",action="reject"} 2
+test_total{route="b",action="forward"} 1
+# HELP test_active A test gauge
+# TYPE test_active gauge
+test_active{route="a"} 1
+# HELP test_seconds A test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{target="t",le="0.1"} 1
+test_seconds_bucket{target="t",le="0.5"} 2
+test_seconds_bucket{target="t",le="+Inf"} 3
+test_seconds_sum{target="t"} 7.35
+test_seconds_count{target="t"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Outcomes of accepted client connections, for ConnectionsTotal
+const (
+	OutcomeForwarded          = "forwarded"
+	OutcomeForwardFailed      = "forward_failed" // failed to send the handshake to the target
+	OutcomeStatusProxied      = "status_proxied"
+	OutcomeDialFailed         = "dial_failed"
+	OutcomeRejected           = "rejected" // by the reject action
+	OutcomeNoRoute            = "no_route"
+	OutcomeUnsupportedVersion = "unsupported_version"
+	OutcomePlayerRefused      = "player_refused"
+	OutcomeIpFiltered         = "ip_filtered"
+	OutcomeRateLimited        = "rate_limited"
+	OutcomeHandshakeFailed    = "handshake_failed"
+	OutcomeShuttingDown       = "shutting_down" // disconnected since the router is shutting down
+)
+
+// Reasons of handshake failures, for HandshakeFailuresTotal
+const (
+	HandshakeFailureTimeout       = "timeout"
+	HandshakeFailureBadHandshake  = "bad_handshake"
+	HandshakeFailureBadLoginStart = "bad_login_start"
+	HandshakeFailureBadRealIp     = "bad_real_ip" // the real ip encoded in the hostname is missing or invalid
+)
+
+// Directions of forwarded bytes, for TransferredBytesTotal
+const (
+	DirectionServerbound = "serverbound" // client -> target
+	DirectionClientbound = "clientbound" // target -> client
+)
+
+var dialDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	DefaultRegistry = NewRegistry()
+
+	ConnectionsTotal       = DefaultRegistry.NewCounterVec("smcr_connections_total", "Accepted client connections by outcome", "outcome")
+	RouteConnectionsTotal  = DefaultRegistry.NewCounterVec("smcr_route_connections_total", "Client connections by the selected route and its action", "route", "action")
+	HandshakeFailuresTotal = DefaultRegistry.NewCounterVec("smcr_handshake_failures_total", "Failures of reading the handshake from clients by reason", "reason")
+	DialDuration           = DefaultRegistry.NewHistogramVec("smcr_dial_duration_seconds", "Time spent on successful dials to targets", dialDurationBuckets, "target")
+	DialFailuresTotal      = DefaultRegistry.NewCounterVec("smcr_dial_failures_total", "Failed dials to targets", "target")
+	ActiveForwards         = DefaultRegistry.NewGaugeVec("smcr_active_forwards", "Connections being forwarded by route", "route")
+	TransferredBytesTotal  = DefaultRegistry.NewCounterVec("smcr_transferred_bytes_total", "Bytes forwarded between clients and targets by route and direction", "route", "direction")
+)
+
+// Serve starts the http server exposing DefaultRegistry at /metrics, until stopCh is closed
+func Serve(address string, stopCh <-chan struct{}) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Errorf("Failed to listen on %s for metrics: %v", address, err)
+		return
+	}
+	log.Infof("Metrics endpoint listening on %s", address)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", DefaultRegistry)
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-stopCh
+		_ = server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("Metrics server error: %v", err)
+	}
+}
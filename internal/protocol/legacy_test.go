@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func readLegacyPing(t *testing.T, data []byte) *LegacyServerListPingPacket {
+	packet, err := ReadHandshakePacket(NewBufferReadWriter(bytes.NewBuffer(data)))
+	if err != nil {
+		t.Fatalf("Failed to read legacy ping %v: %v", data, err)
+	}
+	lp, ok := packet.(*LegacyServerListPingPacket)
+	if !ok {
+		t.Fatalf("Packet %+v is not a legacy ping", packet)
+	}
+	return lp
+}
+
+func writePacketBytes(t *testing.T, packet Packet) []byte {
+	buf := &bytes.Buffer{}
+	if err := WritePacket(NewBufferReadWriter(buf), packet); err != nil {
+		t.Fatalf("Failed to write packet %+v: %v", packet, err)
+	}
+	return buf.Bytes()
+}
+
+func TestLegacyPingVariants(t *testing.T) {
+	if p := readLegacyPing(t, []byte{0xFE}); p.Variant != LegacyPingBeta {
+		t.Errorf("Expected variant %s, found %s", LegacyPingBeta, p.Variant)
+	}
+	if p := readLegacyPing(t, []byte{0xFE, 0x01}); p.Variant != LegacyPing1_4 {
+		t.Errorf("Expected variant %s, found %s", LegacyPing1_4, p.Variant)
+	}
+
+	for _, data := range [][]byte{{0xFE}, {0xFE, 0x01}} {
+		if b := writePacketBytes(t, readLegacyPing(t, data)); !bytes.Equal(b, data) {
+			t.Errorf("Round-trip mismatched, expected %v, found %v", data, b)
+		}
+	}
+}
+
+func TestLegacyPing1_6RoundTrip(t *testing.T) {
+	packet := &LegacyServerListPingPacket{
+		Variant:  LegacyPing1_6,
+		Header:   legacyServerPingHead,
+		Protocol: 78,
+		Hostname: "mc.example.com",
+		Port:     25565,
+	}
+	data := writePacketBytes(t, packet)
+
+	// see https://wiki.vg/Server_List_Ping#1.6, the remaining length is 7 + len(hostname) * 2
+	expectedLen := len(legacyServerPingHead) + 2 + 7 + len(packet.Hostname)*2
+	if len(data) != expectedLen {
+		t.Fatalf("Unexpected packet length %d, expected %d", len(data), expectedLen)
+	}
+
+	read := readLegacyPing(t, data)
+	if read.Variant != packet.Variant || read.Protocol != packet.Protocol || read.Hostname != packet.Hostname || read.Port != packet.Port {
+		t.Errorf("Round-trip mismatched, expected %+v, found %+v", packet, read)
+	}
+}
+
+func TestLegacyKickRoundTrip(t *testing.T) {
+	status := &LegacyStatus{
+		Protocol: 78,
+		Version:  "1.6.4",
+		Motd:     "Server is restarting",
+		Online:   1,
+		Max:      20,
+	}
+	reason := status.Format(LegacyPing1_6)
+	if reason != "§1\x0078\x001.6.4\x00Server is restarting\x001\x0020" {
+		t.Errorf("Unexpected formatted status %q", reason)
+	}
+
+	data := writePacketBytes(t, &LegacyKickPacket{Reason: reason})
+	if data[0] != 0xFF {
+		t.Fatalf("Unexpected packet id %d", data[0])
+	}
+	if l := int(data[1])<<8 | int(data[2]); l != len([]rune(reason)) {
+		t.Fatalf("Unexpected string length %d, expected %d", l, len([]rune(reason)))
+	}
+
+	kick := &LegacyKickPacket{}
+	if err := kick.ReadFrom(NewBufferReadWriter(bytes.NewBuffer(data))); err != nil {
+		t.Fatalf("Failed to read kick packet: %v", err)
+	}
+	if kick.Reason != reason {
+		t.Fatalf("Round-trip mismatched, expected %q, found %q", reason, kick.Reason)
+	}
+	parsed, err := ParseLegacyStatus(kick.Reason)
+	if err != nil {
+		t.Fatalf("Failed to parse legacy status: %v", err)
+	}
+	if *parsed != *status {
+		t.Errorf("Round-trip mismatched, expected %+v, found %+v", status, parsed)
+	}
+}
+
+func TestLegacyKickBetaRoundTrip(t *testing.T) {
+	status := &LegacyStatus{
+		Motd:   "A Minecraft Server",
+		Online: 3,
+		Max:    10,
+	}
+	reason := status.Format(LegacyPingBeta)
+	if reason != "A Minecraft Server§3§10" {
+		t.Errorf("Unexpected formatted status %q", reason)
+	}
+	parsed, err := ParseLegacyStatus(reason)
+	if err != nil {
+		t.Fatalf("Failed to parse legacy status: %v", err)
+	}
+	if *parsed != *status {
+		t.Errorf("Round-trip mismatched, expected %+v, found %+v", status, parsed)
+	}
+}
+
+func TestTextComponentToPlain(t *testing.T) {
+	for input, expected := range map[string]string{
+		`"foo"`: "foo",
+		`{"text": "foo", "color": "red", "extra": [{"text": "bar"}, "baz"]}`: "foobarbaz",
+		`[{"text": "a"}, {"translate": "b.c"}]`:                              "ab.c",
+		`not a json`:                                                         "not a json",
+	} {
+		if actual := TextComponentToPlain(input); actual != expected {
+			t.Errorf("TextComponentToPlain(%s) = %q, expected %q", input, actual, expected)
+		}
+	}
+}
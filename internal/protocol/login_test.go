@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLoginStartVersions(t *testing.T) {
+	uuid, err := ParseUUID("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+	if err != nil {
+		t.Fatalf("Failed to parse uuid: %v", err)
+	}
+	if uuid.String() != "069a79f4-44e9-4726-a5be-fca90e38aaf5" {
+		t.Errorf("Unexpected uuid string %s", uuid.String())
+	}
+	if uuid.PlainString() != "069a79f444e94726a5befca90e38aaf5" {
+		t.Errorf("Unexpected plain uuid string %s", uuid.PlainString())
+	}
+	if offline := OfflinePlayerUUID("Notch"); offline.String() != "b50ad385-829d-3141-a216-7e7d7539ba7f" {
+		t.Errorf("Unexpected offline uuid %s", offline.String())
+	}
+
+	for _, packet := range []*LoginStartPacket{
+		{Protocol: 47, Name: "Notch"},
+		{Protocol: 759, Name: "Notch"},
+		{Protocol: 759, Name: "Notch", SigData: &LoginStartSigData{Timestamp: 123, PublicKey: []byte{1, 2}, Signature: []byte{3}}},
+		{Protocol: 760, Name: "Notch", SigData: &LoginStartSigData{Timestamp: 123, PublicKey: []byte{1, 2}, Signature: []byte{3}}, Uuid: &uuid},
+		{Protocol: 761, Name: "Notch"},
+		{Protocol: 763, Name: "Notch", Uuid: &uuid},
+		{Protocol: 767, Name: "Notch", Uuid: &uuid},
+	} {
+		data := writePacketBytes(t, packet)
+		read, err := ReadLoginStartPacket(NewBufferReadWriter(bytes.NewBuffer(data)), packet.Protocol)
+		if err != nil {
+			t.Fatalf("Failed to read login start packet for protocol %d: %v", packet.Protocol, err)
+		}
+		if read.Name != packet.Name || (read.SigData == nil) != (packet.SigData == nil) || (read.Uuid == nil) != (packet.Uuid == nil) {
+			t.Errorf("Round-trip mismatched for protocol %d, expected %+v, found %+v", packet.Protocol, packet, read)
+		}
+		if read.Uuid != nil && *read.Uuid != *packet.Uuid {
+			t.Errorf("Uuid mismatched for protocol %d, expected %s, found %s", packet.Protocol, packet.Uuid, read.Uuid)
+		}
+
+		// the raw body is kept and written back verbatim
+		if b := writePacketBytes(t, read); !bytes.Equal(b, data) {
+			t.Errorf("Replay mismatched for protocol %d, expected %v, found %v", packet.Protocol, data, b)
+		}
+	}
+
+	if _, err := ReadLoginStartPacket(NewBufferReadWriter(bytes.NewBuffer(writePacketBytes(t, &LoginStartPacket{Protocol: 47, Name: "Notch"}))), 767); err == nil {
+		t.Errorf("Missing uuid should fail to read")
+	}
+}
@@ -7,13 +7,20 @@ import (
 )
 
 const (
-	HandShakePacketId  = 0x00 // handshake state, C2S
-	DisconnectPacketId = 0x00 // login state, S2C
+	HandShakePacketId      = 0x00 // handshake state, C2S
+	DisconnectPacketId     = 0x00 // login state, S2C
+	LoginStartPacketId     = 0x00 // login state, C2S
+	StatusRequestPacketId  = 0x00 // status state, C2S
+	StatusResponsePacketId = 0x00 // status state, S2C
+	PingRequestPacketId    = 0x01 // status state, C2S
+	PongResponsePacketId   = 0x01 // status state, S2C
 
-	HandshakeNextStateStatus = 1
-	HandshakeNextStateLogin  = 2
+	HandshakeNextStateStatus   = 1
+	HandshakeNextStateLogin    = 2
+	HandshakeNextStateTransfer = 3 // since 1.20.5
 
-	legacyHandshakeMagic = 0xFE
+	LegacyHandshakeMagic = 0xFE
+	legacyKickPacketId   = 0xFF
 )
 
 type Packet interface {
@@ -26,6 +33,13 @@ type ModernPacket interface {
 	GetId() int32
 }
 
+// RawPacket keeps the raw packet body (packet ID included) when being read, so it can be written back verbatim
+type RawPacket interface {
+	ModernPacket
+	GetRawBody() []byte
+	SetRawBody(body []byte)
+}
+
 type IHandshakePacket interface {
 	Packet
 	IsLegacy() bool
@@ -91,13 +105,38 @@ func (p *HandshakePacket) WriteTo(writer BufWriter) error {
 	return nil
 }
 
+type LegacyPingVariant int
+
+const (
+	LegacyPingBeta LegacyPingVariant = iota // beta 1.8 - 1.3, only the 0xFE magic
+	LegacyPing1_4                           // 1.4 - 1.5, 0xFE 0x01
+	LegacyPing1_6                           // 1.6, 0xFE 0x01 0xFA with the MC|PingHost plugin message
+)
+
+func (v LegacyPingVariant) String() string {
+	switch v {
+	case LegacyPingBeta:
+		return "beta1.8"
+	case LegacyPing1_4:
+		return "1.4"
+	case LegacyPing1_6:
+		return "1.6"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(v))
+	}
+}
+
 // LegacyServerListPingPacket is in handshake state, C2S
 // see https://wiki.vg/Server_List_Ping#1.6
+//
+// Clients before 1.6 send the packet without the MC|PingHost payload, and wait for the response.
+// Readers should make reads time out in this case, so the variant can be detected
 type LegacyServerListPingPacket struct {
-	Header   []byte // the first 27 bytes of whatever things. see legacyServerPingHead
-	Protocol uint8
-	Hostname string
-	Port     uint16
+	Variant  LegacyPingVariant
+	Header   []byte // the first 27 bytes of whatever things. see legacyServerPingHead. Only for LegacyPing1_6
+	Protocol uint8  // only for LegacyPing1_6
+	Hostname string // only for LegacyPing1_6
+	Port     uint16 // only for LegacyPing1_6
 }
 
 var legacyServerPingHead = []byte{
@@ -121,10 +160,39 @@ func (p *LegacyServerListPingPacket) GetPort() *uint16 {
 }
 
 func (p *LegacyServerListPingPacket) ReadFrom(reader BufReader) error {
-	var err error
-	if p.Header, err = reader.Read(len(legacyServerPingHead)); err != nil {
+	magic, err := reader.ReadUInt8()
+	if err != nil {
+		return fmt.Errorf("failed to read magic: %v", err)
+	}
+	if magic != legacyServerPingHead[0] {
+		return fmt.Errorf("invalid magic %d", magic)
+	}
+
+	payload, err := reader.ReadUInt8()
+	if isEndOfData(err) {
+		p.Variant = LegacyPingBeta
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read payload: %v", err)
+	}
+	if payload != legacyServerPingHead[1] {
+		return fmt.Errorf("invalid payload %d", payload)
+	}
+
+	pluginMessageId, err := reader.ReadUInt8()
+	if isEndOfData(err) {
+		p.Variant = LegacyPing1_4
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read plugin message id: %v", err)
+	}
+
+	p.Variant = LegacyPing1_6
+	rest, err := reader.Read(len(legacyServerPingHead) - 3)
+	if err != nil {
 		return fmt.Errorf("failed to read header: %v", err)
 	}
+	p.Header = append([]byte{magic, payload, pluginMessageId}, rest...)
 	if !bytes.Equal(legacyServerPingHead, p.Header) {
 		return fmt.Errorf("invalid header, expected %v, found %v", legacyServerPingHead, p.Header)
 	}
@@ -151,6 +219,13 @@ func (p *LegacyServerListPingPacket) ReadFrom(reader BufReader) error {
 }
 
 func (p *LegacyServerListPingPacket) WriteTo(writer BufWriter) error {
+	switch p.Variant {
+	case LegacyPingBeta:
+		return writer.Write(legacyServerPingHead[:1])
+	case LegacyPing1_4:
+		return writer.Write(legacyServerPingHead[:2])
+	}
+
 	if err := writer.Write(p.Header); err != nil {
 		return fmt.Errorf("failed to write header: %v", err)
 	}
@@ -180,6 +255,36 @@ func (p *LegacyServerListPingPacket) WriteTo(writer BufWriter) error {
 	return nil
 }
 
+// LegacyKickPacket is the response of LegacyServerListPingPacket, S2C
+// see https://wiki.vg/Server_List_Ping#1.4_to_1.5
+type LegacyKickPacket struct {
+	Reason string // see LegacyStatus
+}
+
+func (p *LegacyKickPacket) ReadFrom(reader BufReader) error {
+	id, err := reader.ReadUInt8()
+	if err != nil {
+		return fmt.Errorf("failed to read packet id: %v", err)
+	}
+	if id != legacyKickPacketId {
+		return fmt.Errorf("invalid packet id %d, should be %d", id, legacyKickPacketId)
+	}
+	if p.Reason, err = reader.ReadUTF16BE(); err != nil {
+		return fmt.Errorf("failed to read reason: %v", err)
+	}
+	return nil
+}
+
+func (p *LegacyKickPacket) WriteTo(writer BufWriter) error {
+	if err := writer.WriteUInt8(legacyKickPacketId); err != nil {
+		return fmt.Errorf("failed to write packet id: %v", err)
+	}
+	if err := writer.WriteUTF16BE(p.Reason); err != nil {
+		return fmt.Errorf("failed to write reason: %v", err)
+	}
+	return nil
+}
+
 // DisconnectPacket is in login state, S2C
 type DisconnectPacket struct {
 	Reason string
@@ -205,3 +310,221 @@ func (p *DisconnectPacket) WriteTo(writer BufWriter) error {
 	}
 	return nil
 }
+
+// StatusRequestPacket is in status state, C2S
+type StatusRequestPacket struct {
+}
+
+var _ ModernPacket = &StatusRequestPacket{}
+
+func (p *StatusRequestPacket) GetId() int32 {
+	return StatusRequestPacketId
+}
+
+func (p *StatusRequestPacket) ReadFrom(reader BufReader) error {
+	return nil
+}
+
+func (p *StatusRequestPacket) WriteTo(writer BufWriter) error {
+	return nil
+}
+
+// StatusResponsePacket is in status state, S2C
+type StatusResponsePacket struct {
+	Response string // json, see StatusResponse
+}
+
+var _ ModernPacket = &StatusResponsePacket{}
+
+func (p *StatusResponsePacket) GetId() int32 {
+	return StatusResponsePacketId
+}
+
+func (p *StatusResponsePacket) ReadFrom(reader BufReader) error {
+	var err error
+	if p.Response, err = reader.ReadString(); err != nil {
+		return fmt.Errorf("failed to read StatusResponsePacket response: %v", err)
+	}
+	return nil
+}
+
+func (p *StatusResponsePacket) WriteTo(writer BufWriter) error {
+	if err := writer.WriteString(p.Response); err != nil {
+		return fmt.Errorf("failed to write StatusResponsePacket response: %v", err)
+	}
+	return nil
+}
+
+// PingRequestPacket is in status state, C2S
+type PingRequestPacket struct {
+	Payload int64
+}
+
+var _ ModernPacket = &PingRequestPacket{}
+
+func (p *PingRequestPacket) GetId() int32 {
+	return PingRequestPacketId
+}
+
+func (p *PingRequestPacket) ReadFrom(reader BufReader) error {
+	var err error
+	if p.Payload, err = reader.ReadInt64(); err != nil {
+		return fmt.Errorf("failed to read PingRequestPacket payload: %v", err)
+	}
+	return nil
+}
+
+func (p *PingRequestPacket) WriteTo(writer BufWriter) error {
+	if err := writer.WriteInt64(p.Payload); err != nil {
+		return fmt.Errorf("failed to write PingRequestPacket payload: %v", err)
+	}
+	return nil
+}
+
+// PongResponsePacket is in status state, S2C
+type PongResponsePacket struct {
+	Payload int64
+}
+
+var _ ModernPacket = &PongResponsePacket{}
+
+func (p *PongResponsePacket) GetId() int32 {
+	return PongResponsePacketId
+}
+
+func (p *PongResponsePacket) ReadFrom(reader BufReader) error {
+	var err error
+	if p.Payload, err = reader.ReadInt64(); err != nil {
+		return fmt.Errorf("failed to read PongResponsePacket payload: %v", err)
+	}
+	return nil
+}
+
+func (p *PongResponsePacket) WriteTo(writer BufWriter) error {
+	if err := writer.WriteInt64(p.Payload); err != nil {
+		return fmt.Errorf("failed to write PongResponsePacket payload: %v", err)
+	}
+	return nil
+}
+
+// LoginStartPacket is in login state, C2S
+// see https://minecraft.wiki/w/Java_Edition_protocol/Packets#Login_Start
+type LoginStartPacket struct {
+	Protocol int32 // the protocol version in the handshake packet, which decides the packet layout. Not a packet field
+
+	Name    string
+	SigData *LoginStartSigData // only for 1.19 ~ 1.19.2 (protocol 759 ~ 760), optional
+	Uuid    *UUID              // optional in 1.19.1 ~ 1.20.1 (protocol 760 ~ 763), required since 1.20.2 (protocol 764)
+
+	rawBody []byte
+}
+
+type LoginStartSigData struct {
+	Timestamp int64
+	PublicKey []byte
+	Signature []byte
+}
+
+const (
+	protocol1_19   = 759
+	protocol1_19_1 = 760
+	protocol1_19_3 = 761
+	protocol1_20_2 = 764
+)
+
+var _ RawPacket = &LoginStartPacket{}
+
+func (p *LoginStartPacket) GetId() int32 {
+	return LoginStartPacketId
+}
+
+func (p *LoginStartPacket) GetRawBody() []byte {
+	return p.rawBody
+}
+
+func (p *LoginStartPacket) SetRawBody(body []byte) {
+	p.rawBody = body
+}
+
+func (p *LoginStartPacket) ReadFrom(reader BufReader) error {
+	var err error
+	if p.Name, err = reader.ReadString(); err != nil {
+		return fmt.Errorf("failed to read LoginStartPacket name: %v", err)
+	}
+
+	if protocol1_19 <= p.Protocol && p.Protocol <= protocol1_19_1 {
+		hasSigData, err := reader.ReadBool()
+		if err != nil {
+			return fmt.Errorf("failed to read LoginStartPacket has sig data: %v", err)
+		}
+		if hasSigData {
+			p.SigData = &LoginStartSigData{}
+			if p.SigData.Timestamp, err = reader.ReadInt64(); err != nil {
+				return fmt.Errorf("failed to read LoginStartPacket timestamp: %v", err)
+			}
+			if p.SigData.PublicKey, err = reader.ReadByteArray(); err != nil {
+				return fmt.Errorf("failed to read LoginStartPacket public key: %v", err)
+			}
+			if p.SigData.Signature, err = reader.ReadByteArray(); err != nil {
+				return fmt.Errorf("failed to read LoginStartPacket signature: %v", err)
+			}
+		}
+	}
+
+	hasUuid := false
+	if p.Protocol >= protocol1_20_2 {
+		hasUuid = true
+	} else if p.Protocol >= protocol1_19_1 {
+		if hasUuid, err = reader.ReadBool(); err != nil {
+			return fmt.Errorf("failed to read LoginStartPacket has uuid: %v", err)
+		}
+	}
+	if hasUuid {
+		b, err := reader.Read(len(UUID{}))
+		if err != nil {
+			return fmt.Errorf("failed to read LoginStartPacket uuid: %v", err)
+		}
+		p.Uuid = &UUID{}
+		copy(p.Uuid[:], b)
+	}
+	return nil
+}
+
+func (p *LoginStartPacket) WriteTo(writer BufWriter) error {
+	if err := writer.WriteString(p.Name); err != nil {
+		return fmt.Errorf("failed to write LoginStartPacket name: %v", err)
+	}
+
+	if protocol1_19 <= p.Protocol && p.Protocol <= protocol1_19_1 {
+		if err := writer.WriteBool(p.SigData != nil); err != nil {
+			return fmt.Errorf("failed to write LoginStartPacket has sig data: %v", err)
+		}
+		if p.SigData != nil {
+			if err := writer.WriteInt64(p.SigData.Timestamp); err != nil {
+				return fmt.Errorf("failed to write LoginStartPacket timestamp: %v", err)
+			}
+			if err := writer.WriteByteArray(p.SigData.PublicKey); err != nil {
+				return fmt.Errorf("failed to write LoginStartPacket public key: %v", err)
+			}
+			if err := writer.WriteByteArray(p.SigData.Signature); err != nil {
+				return fmt.Errorf("failed to write LoginStartPacket signature: %v", err)
+			}
+		}
+	}
+
+	if p.Protocol >= protocol1_20_2 {
+		if p.Uuid == nil {
+			return fmt.Errorf("LoginStartPacket uuid is required since protocol %d", protocol1_20_2)
+		}
+	} else if p.Protocol >= protocol1_19_1 {
+		if err := writer.WriteBool(p.Uuid != nil); err != nil {
+			return fmt.Errorf("failed to write LoginStartPacket has uuid: %v", err)
+		}
+	}
+	if p.Uuid != nil && p.Protocol >= protocol1_19_1 {
+		if err := writer.Write(p.Uuid[:]); err != nil {
+			return fmt.Errorf("failed to write LoginStartPacket uuid: %v", err)
+		}
+	}
+	return nil
+}
@@ -24,9 +24,12 @@ type BufReader interface {
 	ReadInt16() (int16, error)   // Short
 	ReadUInt32() (uint32, error) // Unsigned Int
 	ReadInt32() (int32, error)   // Int
+	ReadInt64() (int64, error)   // Long
 
+	ReadBool() (bool, error)
 	ReadVarInt() (int32, error)
 	ReadString() (string, error)
+	ReadByteArray() ([]byte, error) // VarInt length prefixed
 	ReadUTF16BE() (string, error)
 }
 
@@ -39,9 +42,12 @@ type BufWriter interface {
 	WriteInt16(value int16) error   // Short
 	WriteUInt32(value uint32) error // Unsigned Int
 	WriteInt32(value int32) error   // Int
+	WriteInt64(value int64) error   // Long
 
+	WriteBool(value bool) error
 	WriteVarInt(value int32) error
 	WriteString(s string) error
+	WriteByteArray(b []byte) error // VarInt length prefixed
 	WriteUTF16BE(s string) error
 }
 
@@ -200,6 +206,38 @@ func (p *bufReadWriterImpl) WriteInt32(value int32) error {
 	return p.WriteUInt32(uint32(value))
 }
 
+func (p *bufReadWriterImpl) ReadInt64() (int64, error) {
+	b, err := p.Read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (p *bufReadWriterImpl) WriteInt64(value int64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(value))
+	return p.Write(b)
+}
+
+func (p *bufReadWriterImpl) ReadBool() (bool, error) {
+	value, err := p.ReadUInt8()
+	if err != nil {
+		return false, err
+	}
+	if value > 1 {
+		return false, fmt.Errorf("invalid boolean value %d", value)
+	}
+	return value == 1, nil
+}
+
+func (p *bufReadWriterImpl) WriteBool(value bool) error {
+	if value {
+		return p.WriteUInt8(1)
+	}
+	return p.WriteUInt8(0)
+}
+
 func (p *bufReadWriterImpl) ReadVarInt() (int32, error) {
 	var value int32 = 0
 	position := 0
@@ -259,6 +297,24 @@ func (p *bufReadWriterImpl) WriteString(s string) error {
 	return p.Write([]byte(s))
 }
 
+func (p *bufReadWriterImpl) ReadByteArray() ([]byte, error) {
+	length, err := p.ReadVarInt()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("negative byte array length %d", length)
+	}
+	return p.Read(int(length))
+}
+
+func (p *bufReadWriterImpl) WriteByteArray(b []byte) error {
+	if err := p.WriteVarInt(int32(len(b))); err != nil {
+		return err
+	}
+	return p.Write(b)
+}
+
 func (p *bufReadWriterImpl) ReadUTF16BE() (string, error) {
 	strLen, err := p.ReadInt16()
 	if err != nil {
@@ -294,7 +350,7 @@ func (p *bufReadWriterImpl) WriteUTF16BE(s string) error {
 	}
 
 	b := buf.Bytes()
-	if err := p.WriteInt16(int16(len(b))); err != nil {
+	if err := p.WriteInt16(int16(len(u16s))); err != nil { // length in characters
 		return err
 	}
 	if err := p.Write(b); err != nil {
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// TextComponentToPlain converts a text component json into plain text, for clients that do not support text components.
+// Formatting is dropped, and translatable components are rendered as their translation keys.
+// If the input is not a valid json, it's returned as-is
+func TextComponentToPlain(componentJson string) string {
+	var component interface{}
+	if err := json.Unmarshal([]byte(componentJson), &component); err != nil {
+		return componentJson
+	}
+	var sb strings.Builder
+	writePlainText(&sb, component)
+	return sb.String()
+}
+
+func writePlainText(sb *strings.Builder, component interface{}) {
+	switch c := component.(type) {
+	case string:
+		sb.WriteString(c)
+	case []interface{}:
+		for _, child := range c {
+			writePlainText(sb, child)
+		}
+	case map[string]interface{}:
+		if text, ok := c["text"].(string); ok {
+			sb.WriteString(text)
+		} else if translate, ok := c["translate"].(string); ok {
+			sb.WriteString(translate)
+		}
+		if extra, ok := c["extra"]; ok {
+			writePlainText(sb, extra)
+		}
+	}
+}
@@ -1 +1,139 @@
 package protocol
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusResponse is the json object in StatusResponsePacket
+// see https://minecraft.wiki/w/Java_Edition_protocol/Server_List_Ping#Status_Response
+type StatusResponse struct {
+	Version     StatusVersion   `json:"version"`
+	Players     StatusPlayers   `json:"players"`
+	Description json.RawMessage `json:"description"` // a text component
+	Favicon     string          `json:"favicon,omitempty"`
+}
+
+type StatusVersion struct {
+	Name     string `json:"name"`
+	Protocol int32  `json:"protocol"`
+}
+
+type StatusPlayers struct {
+	Max    int                  `json:"max"`
+	Online int                  `json:"online"`
+	Sample []StatusPlayerSample `json:"sample,omitempty"`
+}
+
+type StatusPlayerSample struct {
+	Name string `json:"name"`
+	Id   string `json:"id"`
+}
+
+// UUID is a 128-bit player UUID, in big-endian order
+type UUID [16]byte
+
+// String returns the UUID in the hyphenated form, e.g. "069a79f4-44e9-4726-a5be-fca90e38aaf5"
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// PlainString returns the UUID without hyphens, e.g. "069a79f444e94726a5befca90e38aaf5"
+func (u UUID) PlainString() string {
+	return hex.EncodeToString(u[:])
+}
+
+// OfflinePlayerUUID returns the UUID of the player in offline mode servers,
+// i.e. the version 3 UUID of "OfflinePlayer:<name>", the same as Java's UUID.nameUUIDFromBytes
+func OfflinePlayerUUID(name string) UUID {
+	u := UUID(md5.Sum([]byte("OfflinePlayer:" + name)))
+	u[6] = u[6]&0x0f | 0x30 // version 3
+	u[8] = u[8]&0x3f | 0x80 // IETF variant
+	return u
+}
+
+// ParseUUID parses a UUID in either the hyphenated form or the plain 32-hex-digit form
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	h := strings.ReplaceAll(s, "-", "")
+	if len(h) != 32 {
+		return u, fmt.Errorf("invalid uuid %s", s)
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return u, fmt.Errorf("invalid uuid %s: %v", s, err)
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+// LegacyStatus is the server list information in a LegacyKickPacket
+type LegacyStatus struct {
+	Protocol int32  // not available in LegacyPingBeta
+	Version  string // not available in LegacyPingBeta
+	Motd     string
+	Online   int
+	Max      int
+}
+
+// Format encodes the status into the reason of a LegacyKickPacket, in the layout that the given ping variant expects
+func (s *LegacyStatus) Format(variant LegacyPingVariant) string {
+	if variant == LegacyPingBeta {
+		// beta clients use '§' as the delimiter, so it cannot appear in the MOTD
+		motd := strings.ReplaceAll(s.Motd, "§", "")
+		return fmt.Sprintf("%s§%d§%d", motd, s.Online, s.Max)
+	}
+	return strings.Join([]string{
+		"§1",
+		strconv.Itoa(int(s.Protocol)),
+		s.Version,
+		s.Motd,
+		strconv.Itoa(s.Online),
+		strconv.Itoa(s.Max),
+	}, "\x00")
+}
+
+// ParseLegacyStatus decodes the reason of a LegacyKickPacket, in either the "§1" layout or the beta layout
+func ParseLegacyStatus(reason string) (*LegacyStatus, error) {
+	var err error
+	status := &LegacyStatus{}
+	if strings.HasPrefix(reason, "§1\x00") {
+		fields := strings.Split(reason, "\x00")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("expected 6 fields, found %d", len(fields))
+		}
+		protocol, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid protocol %s: %v", fields[1], err)
+		}
+		status.Protocol = int32(protocol)
+		status.Version = fields[2]
+		status.Motd = fields[3]
+		if status.Online, err = strconv.Atoi(fields[4]); err != nil {
+			return nil, fmt.Errorf("invalid online player count %s: %v", fields[4], err)
+		}
+		if status.Max, err = strconv.Atoi(fields[5]); err != nil {
+			return nil, fmt.Errorf("invalid max player count %s: %v", fields[5], err)
+		}
+		return status, nil
+	}
+
+	fields := strings.Split(reason, "§")
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected at least 3 fields, found %d", len(fields))
+	}
+	n := len(fields)
+	status.Motd = strings.Join(fields[:n-2], "§")
+	if status.Online, err = strconv.Atoi(fields[n-2]); err != nil {
+		return nil, fmt.Errorf("invalid online player count %s: %v", fields[n-2], err)
+	}
+	if status.Max, err = strconv.Atoi(fields[n-1]); err != nil {
+		return nil, fmt.Errorf("invalid max player count %s: %v", fields[n-1], err)
+	}
+	return status, nil
+}
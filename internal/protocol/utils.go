@@ -2,7 +2,10 @@ package protocol
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 )
 
 func ReadHandshakePacket(reader BufReader) (IHandshakePacket, error) {
@@ -12,7 +15,7 @@ func ReadHandshakePacket(reader BufReader) (IHandshakePacket, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to peek the first byte: %v", err)
 	}
-	if head == legacyHandshakeMagic {
+	if head == LegacyHandshakeMagic {
 		return readLegacyServerListPing(reader)
 	}
 
@@ -52,6 +55,9 @@ func ReadModernPacket(reader BufReader, packetFactory func(int32) (ModernPacket,
 	if err != nil {
 		return nil, fmt.Errorf("failed to create packet for ID %d: %v", packetId, err)
 	}
+	if rp, ok := packet.(RawPacket); ok {
+		rp.SetRawBody(packetBody)
+	}
 
 	if err := packet.ReadFrom(bodyReader); err != nil {
 		return nil, fmt.Errorf("failed to deserialize packet fields: %v", err)
@@ -71,8 +77,33 @@ func readLegacyServerListPing(reader BufReader) (*LegacyServerListPingPacket, er
 	return &packet, nil
 }
 
+// isEndOfData checks if the read error is caused by the peer not sending anything more,
+// i.e. the connection is closed, or the read deadline is exceeded
+func isEndOfData(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// ReadLoginStartPacket reads the login start packet, whose layout depends on the protocol version in the handshake packet
+func ReadLoginStartPacket(reader BufReader, protocol int32) (*LoginStartPacket, error) {
+	packet := LoginStartPacket{Protocol: protocol}
+	if err := ReadExpectedPacket(reader, &packet); err != nil {
+		return nil, err
+	}
+	return &packet, nil
+}
+
 func WritePacket(writer BufWriter, packet Packet) error {
-	if mp, ok := packet.(ModernPacket); ok {
+	if rp, ok := packet.(RawPacket); ok && rp.GetRawBody() != nil {
+		// write it back verbatim
+		if err := writer.WriteVarInt(int32(len(rp.GetRawBody()))); err != nil {
+			return fmt.Errorf("failed to write packet length: %v", err)
+		}
+		if err := writer.Write(rp.GetRawBody()); err != nil {
+			return fmt.Errorf("failed to write packet body: %v", err)
+		}
+		return nil
+
+	} else if mp, ok := packet.(ModernPacket); ok {
 		bodyWriter := NewBufferReadWriter(&bytes.Buffer{})
 		if err := bodyWriter.WriteVarInt(mp.GetId()); err != nil {
 			return fmt.Errorf("failed to write packet id: %v", err)
@@ -97,7 +128,24 @@ func WritePacket(writer BufWriter, packet Packet) error {
 	} else if lp, ok := packet.(*LegacyServerListPingPacket); ok {
 		return lp.WriteTo(writer)
 
+	} else if lp, ok := packet.(*LegacyKickPacket); ok {
+		return lp.WriteTo(writer)
+
 	} else {
 		return fmt.Errorf("unsupported packet %+v", packet)
 	}
 }
+
+// ReadExpectedPacket reads a modern packet into the given packet. The packet ID should match the given packet's ID
+func ReadExpectedPacket(reader BufReader, packet ModernPacket) error {
+	_, err := ReadModernPacket(
+		reader,
+		func(packetId int32) (ModernPacket, error) {
+			if packetId == packet.GetId() {
+				return packet, nil
+			}
+			return nil, fmt.Errorf("unexpected packet ID %d, should be %d", packetId, packet.GetId())
+		},
+	)
+	return err
+}
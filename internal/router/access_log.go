@@ -0,0 +1,71 @@
+package router
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	"github.com/Fallen-Breath/smcr/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// accessRecord is the access log record of a finished connection
+type accessRecord struct {
+	Time             time.Time `json:"time"`
+	ClientId         int       `json:"client_id"`
+	Listener         string    `json:"listener"`
+	ClientIp         string    `json:"client_ip"`
+	Hostname         string    `json:"hostname,omitempty"`
+	Port             uint16    `json:"port,omitempty"`
+	Protocol         int32     `json:"protocol,omitempty"`
+	NextState        string    `json:"next_state,omitempty"`
+	Player           string    `json:"player,omitempty"`
+	Route            string    `json:"route,omitempty"`
+	Target           string    `json:"target,omitempty"`
+	Outcome          string    `json:"outcome"`
+	Duration         float64   `json:"duration"` // in seconds
+	ServerboundBytes int64     `json:"serverbound_bytes"`
+	ClientboundBytes int64     `json:"clientbound_bytes"`
+}
+
+type accessLog struct {
+	config config.AccessLog
+	file   *logging.RotatingFile
+}
+
+// updateAccessLog reopens the access log file, if the access log config changes
+func (r *MinecraftRouter) updateAccessLog(cfg *config.Config) {
+	oldLog := r.accessLog.Load()
+	var newLog *accessLog
+	if cfg.AccessLog != nil {
+		if oldLog != nil && oldLog.config == *cfg.AccessLog {
+			return
+		}
+		file, err := logging.OpenRotatingFile(cfg.AccessLog.File, int64(cfg.AccessLog.MaxSize)*1024*1024, cfg.AccessLog.MaxBackups)
+		if err != nil {
+			log.Errorf("Failed to open access log file %s, access log disabled: %v", cfg.AccessLog.File, err)
+		} else {
+			log.Infof("Writing access log to %s", cfg.AccessLog.File)
+			newLog = &accessLog{config: *cfg.AccessLog, file: file}
+		}
+	}
+	r.accessLog.Store(newLog)
+	if oldLog != nil {
+		_ = oldLog.file.Close()
+	}
+}
+
+func (r *MinecraftRouter) writeAccessLog(record *accessRecord) {
+	accessLog := r.accessLog.Load()
+	if accessLog == nil {
+		return
+	}
+	buf, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Failed to marshal access log record: %v", err)
+		return
+	}
+	if _, err := accessLog.file.Write(append(buf, '\n')); err != nil {
+		log.Errorf("Failed to write access log: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+type adminTargetView struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight"`
+	Healthy bool   `json:"healthy"`
+}
+
+type adminRouteView struct {
+	Name        string            `json:"name"`
+	Table       string            `json:"table"` // where the route is declared, e.g. "routes", "route_sets.lan"
+	Matches     []string          `json:"matches"`
+	Action      string            `json:"action"`
+	Targets     []adminTargetView `json:"targets,omitempty"`
+	Fallbacks   []string          `json:"fallbacks,omitempty"`
+	Connections int               `json:"connections"`
+}
+
+// adminRouteKey identifies a route in the admin api. Route names are only unique inside a route table
+type adminRouteKey struct {
+	table string
+	name  string
+}
+
+// serveAdmin serves the admin api on the given address, until stopCh is closed
+//
+//	GET    /connections       list active connections
+//	GET    /connections/<id>  show an active connection
+//	DELETE /connections/<id>  force-close an active connection
+//	GET    /routes            list the current routes
+func (r *MinecraftRouter) serveAdmin(address string, stopCh <-chan struct{}) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Errorf("Failed to listen on %s for admin api: %v", address, err)
+		return
+	}
+	log.Infof("Admin api listening on %s", address)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connections", r.handleAdminConnections)
+	mux.HandleFunc("/connections/", r.handleAdminConnection)
+	mux.HandleFunc("/routes", r.handleAdminRoutes)
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-stopCh
+		_ = server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("Admin server error: %v", err)
+	}
+}
+
+func writeAdminJson(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Debugf("Failed to write admin api response: %v", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, code int, message string) {
+	writeAdminJson(w, code, map[string]string{"error": message})
+}
+
+func (r *MinecraftRouter) handleAdminConnections(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	views := make([]connectionView, 0)
+	for _, info := range r.connections.List() {
+		views = append(views, info.view())
+	}
+	writeAdminJson(w, http.StatusOK, views)
+}
+
+func (r *MinecraftRouter) handleAdminConnection(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/connections/"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid connection id")
+		return
+	}
+	info := r.connections.Get(id)
+	if info == nil {
+		writeAdminError(w, http.StatusNotFound, "connection not found")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeAdminJson(w, http.StatusOK, info.view())
+	case http.MethodDelete:
+		log.Infof("Closing connection #%d from %s by admin request", id, info.view().ClientAddr)
+		info.kick()
+		writeAdminJson(w, http.StatusOK, info.view())
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (r *MinecraftRouter) handleAdminRoutes(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	connCounts := make(map[adminRouteKey]int)
+	for _, info := range r.connections.List() {
+		if view := info.view(); len(view.Route) > 0 {
+			connCounts[adminRouteKey{table: view.RouteTable, name: view.Route}]++
+		}
+	}
+
+	cfg := r.GetConfig()
+	views := make([]adminRouteView, 0)
+	for _, table := range cfg.GetRouteTables() {
+		for i := range table.Routes {
+			views = append(views, r.adminRouteView(table, &table.Routes[i], connCounts))
+		}
+	}
+	writeAdminJson(w, http.StatusOK, views)
+}
+
+func (r *MinecraftRouter) adminRouteView(table *config.RouteTable, route *config.Route, connCounts map[adminRouteKey]int) adminRouteView {
+	view := adminRouteView{
+		Name:        route.Name,
+		Table:       table.Name,
+		Matches:     route.Matches,
+		Action:      string(route.Action),
+		Fallbacks:   route.Fallbacks,
+		Connections: connCounts[adminRouteKey{table: table.Name, name: route.Name}],
+	}
+	if route.Action == config.Forward {
+		for _, target := range route.Targets {
+			view.Targets = append(view.Targets, adminTargetView{
+				Address: target.Address,
+				Weight:  target.Weight,
+				Healthy: r.healthChecker.IsHealthy(target.Address, route),
+			})
+		}
+	}
+	return view
+}
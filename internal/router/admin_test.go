@@ -0,0 +1,83 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+)
+
+func TestAdminConnections(t *testing.T) {
+	r := NewMinecraftRouter(&config.Config{})
+	closed := false
+	info := &connectionInfo{id: 3, clientAddr: "1.2.3.4:5678", startTime: time.Now(), closeFunc: func() { closed = true }}
+	info.route = "lobby"
+	info.serverbound.Add(100)
+	r.connections.Register(info)
+	r.connections.Register(&connectionInfo{id: 1, clientAddr: "1.2.3.5:5678", startTime: time.Now()})
+
+	rec := httptest.NewRecorder()
+	r.handleAdminConnections(rec, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	var views []connectionView
+	if err := json.Unmarshal(rec.Body.Bytes(), &views); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", rec.Body.String(), err)
+	}
+	if len(views) != 2 || views[0].Id != 1 || views[1].Id != 3 || views[1].Route != "lobby" || views[1].ServerboundBytes != 100 {
+		t.Errorf("Unexpected connections %+v", views)
+	}
+
+	for path, code := range map[string]int{"/connections/2": http.StatusNotFound, "/connections/x": http.StatusBadRequest} {
+		rec = httptest.NewRecorder()
+		r.handleAdminConnection(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		if rec.Code != code {
+			t.Errorf("Unexpected status code %d for %s, expected %d", rec.Code, path, code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	r.handleAdminConnection(rec, httptest.NewRequest(http.MethodDelete, "/connections/3", nil))
+	if rec.Code != http.StatusOK || !closed || !info.kicked.Load() {
+		t.Errorf("Connection is not closed, status code %d", rec.Code)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	cfg := newTestConfig(t, `
+listeners:
+  - listen: 0.0.0.0:7777
+  - listen: 0.0.0.0:7778
+    route_set: lan
+routes:
+  - name: lobby
+    matches: [mc.example.com]
+    target: 10.0.0.1:25565
+route_sets:
+  lan:
+    - name: lobby
+      matches: [mc.lan]
+      target: 10.0.0.2:25565
+`)
+	r := NewMinecraftRouter(cfg)
+	for i, table := range []string{"routes", "route_sets.lan", "route_sets.lan"} {
+		info := &connectionInfo{id: i, startTime: time.Now()}
+		info.route, info.routeTable = "lobby", table
+		r.connections.Register(info)
+	}
+
+	rec := httptest.NewRecorder()
+	r.handleAdminRoutes(rec, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	var views []adminRouteView
+	if err := json.Unmarshal(rec.Body.Bytes(), &views); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", rec.Body.String(), err)
+	}
+	connections := make(map[string]int)
+	for _, view := range views {
+		connections[view.Table] = view.Connections
+	}
+	if len(views) != 2 || connections["routes"] != 1 || connections["route_sets.lan"] != 2 {
+		t.Errorf("Connections should be counted per route table, found %+v", views)
+	}
+}
@@ -0,0 +1,137 @@
+package router
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadBalancer picks targets for routes, and keeps track of the live connection count of each target
+type loadBalancer struct {
+	mutex       sync.Mutex
+	rrCounters  map[*config.Route]uint64 // round-robin counters. Keyed by the route pointer, since route names might be empty or duplicated across route tables
+	activeConns map[string]int           // target address -> active connection count
+}
+
+type balanceTarget struct {
+	Address  string // with template vars expanded
+	Template string // as configured
+	Weight   int
+}
+
+func newLoadBalancer() *loadBalancer {
+	return &loadBalancer{
+		rrCounters:  make(map[*config.Route]uint64),
+		activeConns: make(map[string]int),
+	}
+}
+
+// Pick returns the index of the selected target. targets should not be empty
+func (b *loadBalancer) Pick(route *config.Route, targets []balanceTarget) int {
+	if len(targets) == 1 {
+		return 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch route.Balance {
+	case config.Weighted:
+		total := 0
+		for _, t := range targets {
+			total += t.Weight
+		}
+		if total > 0 {
+			n := rand.Intn(total)
+			for i, t := range targets {
+				if n < t.Weight {
+					return i
+				}
+				n -= t.Weight
+			}
+		}
+		return 0
+
+	case config.LeastConnections:
+		best := 0
+		for i := 1; i < len(targets); i++ {
+			// compare activeConns[i] / weight[i] with activeConns[best] / weight[best]
+			if b.activeConns[targets[i].Address]*targets[best].Weight < b.activeConns[targets[best].Address]*targets[i].Weight {
+				best = i
+			}
+		}
+		return best
+
+	default: // config.RoundRobin
+		counter := b.rrCounters[route]
+		b.rrCounters[route] = counter + 1
+		return int(counter % uint64(len(targets)))
+	}
+}
+
+// ResetRoutes drops the round-robin counters, so the routes of the old config can be garbage collected after a config reload
+func (b *loadBalancer) ResetRoutes() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.rrCounters = make(map[*config.Route]uint64)
+}
+
+// Acquire marks a new active connection to the given target. The returned function releases it
+func (b *loadBalancer) Acquire(address string) (release func()) {
+	b.mutex.Lock()
+	b.activeConns[address]++
+	b.mutex.Unlock()
+
+	return onceFunc(func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if b.activeConns[address]--; b.activeConns[address] <= 0 {
+			delete(b.activeConns, address)
+		}
+	})
+}
+
+// selectTargets returns the addresses to dial for the route, in order: the target picked by the balancer,
+// then the other healthy targets, then the healthy fallback targets
+func (r *MinecraftRouter) selectTargets(route *config.Route, match *config.RouteMatch, logger *log.Entry) []dialTarget {
+	var targets []balanceTarget
+	for _, t := range route.Targets {
+		address, err := match.ExpandAddress(t.Address)
+		if err != nil {
+			logger.Warnf("Skipped target %s: %v", t.Address, err)
+			continue
+		}
+		if !r.healthChecker.IsHealthy(address, route) {
+			logger.Debugf("Skipped unhealthy target %s", address)
+			continue
+		}
+		targets = append(targets, balanceTarget{Address: address, Template: t.Address, Weight: t.Weight})
+	}
+
+	var dialAddresses []dialTarget
+	if len(targets) > 0 {
+		selectedIdx := r.balancer.Pick(route, targets)
+		if len(targets) > 1 {
+			logger.Infof("Selected target %s from %d targets with strategy %s", targets[selectedIdx].Address, len(targets), route.Balance)
+		}
+		for i := range targets {
+			target := targets[(selectedIdx+i)%len(targets)]
+			dialAddresses = append(dialAddresses, dialTarget{Address: target.Address, Template: target.Template})
+		}
+	}
+	for _, fallback := range route.Fallbacks {
+		address, err := match.ExpandAddress(fallback)
+		if err != nil {
+			logger.Warnf("Skipped fallback target %s: %v", fallback, err)
+			continue
+		}
+		if !r.healthChecker.IsHealthy(address, route) {
+			logger.Debugf("Skipped unhealthy fallback target %s", address)
+			continue
+		}
+		dialAddresses = append(dialAddresses, dialTarget{Address: address, Template: fallback})
+	}
+	return dialAddresses
+}
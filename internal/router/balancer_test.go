@@ -0,0 +1,124 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+func newTestConfig(t *testing.T, content string) *config.Config {
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+		t.Fatalf("Failed to parse yaml: %v", err)
+	}
+	if _, err := cfg.Init(); err != nil {
+		t.Fatalf("Failed to init config: %v", err)
+	}
+	return &cfg
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b := newLoadBalancer()
+	targets := []balanceTarget{{Address: "a", Weight: 1}, {Address: "b", Weight: 1}, {Address: "c", Weight: 1}}
+	// same name, different routes, e.g. in different route tables
+	route1 := &config.Route{Name: "lobby", Balance: config.RoundRobin}
+	route2 := &config.Route{Name: "lobby", Balance: config.RoundRobin}
+
+	var picks []int
+	for i := 0; i < 4; i++ {
+		picks = append(picks, b.Pick(route1, targets))
+	}
+	picks = append(picks, b.Pick(route2, targets), b.Pick(route1, targets))
+	if expected := []int{0, 1, 2, 0, 0, 1}; !equalInts(picks, expected) {
+		t.Errorf("Unexpected picks %v, expected %v", picks, expected)
+	}
+
+	b.ResetRoutes()
+	if pick := b.Pick(route1, targets); pick != 0 {
+		t.Errorf("Counter should start over after reset, found %d", pick)
+	}
+}
+
+func TestBalancerLeastConnections(t *testing.T) {
+	b := newLoadBalancer()
+	route := &config.Route{Balance: config.LeastConnections}
+	targets := []balanceTarget{{Address: "a", Weight: 1}, {Address: "b", Weight: 1}, {Address: "c", Weight: 2}}
+
+	if pick := b.Pick(route, targets); pick != 0 {
+		t.Errorf("The first target should win a tie, found %d", pick)
+	}
+	releaseA := b.Acquire("a")
+	if pick := b.Pick(route, targets); pick != 1 {
+		t.Errorf("Expected target b, found %d", pick)
+	}
+	releaseB := b.Acquire("b")
+	if pick := b.Pick(route, targets); pick != 2 {
+		t.Errorf("Expected target c, found %d", pick)
+	}
+	// c has weight 2, so 2 connections to c equal 1 connection to a or b
+	releaseC1, releaseC2 := b.Acquire("c"), b.Acquire("c")
+	if pick := b.Pick(route, targets); pick != 0 {
+		t.Errorf("Expected target a for a tie, found %d", pick)
+	}
+	releaseA()
+	if pick := b.Pick(route, targets); pick != 0 {
+		t.Errorf("Expected target a after its connection is released, found %d", pick)
+	}
+	releaseB()
+	releaseC1()
+	releaseC2()
+	if len(b.activeConns) != 0 {
+		t.Errorf("Released targets should be removed, found %v", b.activeConns)
+	}
+}
+
+func TestSelectTargetsSkipsUnhealthy(t *testing.T) {
+	cfg := newTestConfig(t, `
+listen: 0.0.0.0:7777
+routes:
+  - name: lobby
+    matches: [mc.example.com]
+    targets: [10.0.0.1:25565, 10.0.0.2:25565, 10.0.0.3:25565]
+    fallbacks: [10.0.0.4:25565, 10.0.0.5:25565]
+`)
+	r := NewMinecraftRouter(cfg)
+	route := &cfg.GetRouteTables()[0].Routes[0]
+	for _, address := range []string{"10.0.0.2:25565", "10.0.0.4:25565"} {
+		r.healthChecker.states[healthCheckTarget{address: address}] = &targetHealth{healthy: false}
+	}
+
+	logger := log.WithField("test", t.Name())
+	var rounds []string
+	for i := 0; i < 3; i++ {
+		var addresses []string
+		for _, target := range r.selectTargets(route, &config.RouteMatch{Route: route}, logger) {
+			addresses = append(addresses, target.Address)
+		}
+		rounds = append(rounds, strings.Join(addresses, ","))
+	}
+	expected := []string{
+		"10.0.0.1:25565,10.0.0.3:25565,10.0.0.5:25565",
+		"10.0.0.3:25565,10.0.0.1:25565,10.0.0.5:25565",
+		"10.0.0.1:25565,10.0.0.3:25565,10.0.0.5:25565",
+	}
+	for i := range rounds {
+		if rounds[i] != expected[i] {
+			t.Errorf("Round %d: expected %s, found %s", i, expected[i], rounds[i])
+		}
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -11,28 +12,43 @@ import (
 	"time"
 
 	"github.com/Fallen-Breath/smcr/internal/config"
-	"github.com/Fallen-Breath/smcr/internal/dns"
+	"github.com/Fallen-Breath/smcr/internal/metrics"
 	"github.com/Fallen-Breath/smcr/internal/protocol"
-	"github.com/pires/go-proxyproto"
 	log "github.com/sirupsen/logrus"
 )
 
 type ConnectionHandler struct {
-	id         int
-	config     *config.Config
-	clientConn net.Conn
-	logger     *log.Entry
+	id           int
+	router       *MinecraftRouter
+	config       *config.Config
+	listenerName string
+	listener     *config.Listener // might be nil, if the listener is removed by a config reload
+	clientConn   net.Conn
+	clientAddr   net.Addr // the address reported by the proxy protocol header, or the real ip decoded from the handshake
+	realIp       bool     // if clientAddr is the real ip decoded from the handshake
+	logger       *log.Entry
+	info         *connectionInfo
 }
 
 const handshakeMaxTimeWait = 30 * time.Second
+const legacyPingMaxTimeWait = 500 * time.Millisecond
+const limitedHandshakeMaxTimeWait = 3 * time.Second
 
-func NewConnectionHandler(id int, cfg *config.Config, clientConn net.Conn) *ConnectionHandler {
+func NewConnectionHandler(id int, router *MinecraftRouter, listenerName string, clientConn net.Conn) *ConnectionHandler {
 	h := &ConnectionHandler{
-		id:         id,
-		config:     cfg,
-		clientConn: clientConn,
+		id:           id,
+		router:       router,
+		config:       router.GetConfig(),
+		listenerName: listenerName,
+		clientConn:   clientConn,
+		clientAddr:   clientConn.RemoteAddr(),
 	}
-	h.logger = log.WithField("client_id", id)
+	h.listener = h.config.GetListener(listenerName)
+	h.logger = log.WithFields(log.Fields{
+		"client_id":   id,
+		"client_addr": h.clientAddr.String(),
+		"listener":    listenerName,
+	})
 	return h
 }
 
@@ -58,13 +74,82 @@ func (h *ConnectionHandler) handleConnection() {
 	})
 	defer closeClientConn()
 
-	if len(h.config.WhitelistedIps) > 0 {
-		clientAddr := h.clientConn.RemoteAddr()
-		if ppConn, ok := h.clientConn.(*proxyproto.Conn); ok {
-			clientAddr = ppConn.Raw().RemoteAddr()
+	h.info = &connectionInfo{
+		id:         h.id,
+		listener:   h.listenerName,
+		clientAddr: h.clientAddr.String(),
+		startTime:  time.Now(),
+		closeFunc:  closeClientConn,
+	}
+	h.router.connections.Register(h.info)
+	defer h.router.connections.Unregister(h.id)
+
+	outcome := ""
+	outcomeCounted := false
+	defer func() {
+		if len(outcome) > 0 && !outcomeCounted {
+			metrics.ConnectionsTotal.Inc(outcome)
+		}
+		h.writeAccessLog(outcome)
+	}()
+
+	if h.listener == nil {
+		h.logger.Warnf("Listener '%s' is removed from the config, closing connection", h.listenerName)
+		outcome = metrics.OutcomeNoRoute
+		return
+	}
+
+	// checks the ip filters and the rate limits of the client ip
+	var releaseLimit func()
+	defer func() {
+		if releaseLimit != nil {
+			releaseLimit()
+		}
+	}()
+	checkClient := func(handshakePacket protocol.IHandshakePacket) bool { // handshakePacket is nil if it's not read yet
+		if !h.checkClientIp(nil) {
+			outcome = metrics.OutcomeIpFiltered
+			return false
+		}
+		if clientIp := addrIp(h.clientAddr); clientIp != nil {
+			release, reason := h.router.limiter.Acquire(clientIp, &h.config.RateLimit)
+			if reason != limitNone {
+				h.logger.Debugf("Rejected since %s", reason)
+				// connections over the total limit are closed right away, to save resources.
+				// Waiting for the handshake is counted against the limiter too, so floods cannot tie up resources
+				if messageJson := h.config.RateLimit.GetMessageJson(); len(messageJson) > 0 && reason != limitConnsInTotal {
+					if handshakePacket != nil {
+						h.disconnectLimitedLogin(handshakePacket, messageJson)
+					} else if releaseWait, ok := h.router.limiter.AcquireRejection(clientIp, &h.config.RateLimit); ok {
+						h.disconnectLimitedLogin(nil, messageJson)
+						releaseWait()
+					}
+				}
+				outcome = metrics.OutcomeRateLimited
+				return false
+			}
+			releaseLimit = release
 		}
-		if !checkIpWhitelist(clientAddr, h.config.WhitelistedIps) {
-			h.logger.Infof("Rejected since client tcp addr %s not in whitelist", clientAddr)
+		return true
+	}
+	// with real_ip, the client ip of trusted upstreams is only known after the handshake is read,
+	// so only the total connection limit is checked for them here. Other peers have no real ip
+	realIpTrusted := h.listener.RealIp.Trusts(tcpRemoteIp(h.clientConn))
+	if realIpTrusted {
+		release, reason := h.router.limiter.AcquireTotal(&h.config.RateLimit)
+		if reason != limitNone {
+			h.logger.Debugf("Rejected since %s", reason)
+			outcome = metrics.OutcomeRateLimited
+			return
+		}
+		releaseLimit = release
+	} else {
+		if h.listener.RealIp.Enabled && h.listener.RealIp.Required {
+			h.logger.Infof("Rejected since the peer %s is not a trusted upstream of real_ip", h.clientConn.RemoteAddr())
+			outcome = metrics.OutcomeIpFiltered
+			return
+		}
+		if !checkClient(nil) {
 			return
 		}
 	}
@@ -78,19 +163,66 @@ func (h *ConnectionHandler) handleConnection() {
 		closeClientConn()
 	})
 	connReadWriter := protocol.NewBufferReadWriter(h.clientConn)
+	if head, err := connReadWriter.PeekByte(); err == nil && head == protocol.LegacyHandshakeMagic {
+		// clients before 1.6 send the legacy ping without the payload, then wait for the response,
+		// so we need a read timeout to detect the ping variant
+		_ = h.clientConn.SetReadDeadline(time.Now().Add(legacyPingMaxTimeWait))
+	}
 	handshakePacket, err := protocol.ReadHandshakePacket(connReadWriter)
-	deadlineTimer.Stop()
+	_ = h.clientConn.SetReadDeadline(time.Time{})
 	if err != nil {
+		deadlineTimer.Stop()
 		if !handshakeTimeout {
 			h.logger.Errorf("Failed to read handshake packet from client: %v", err)
+			metrics.HandshakeFailuresTotal.Inc(metrics.HandshakeFailureBadHandshake)
+		} else {
+			metrics.HandshakeFailuresTotal.Inc(metrics.HandshakeFailureTimeout)
 		}
+		outcome = metrics.OutcomeHandshakeFailed
 		return
 	}
 	h.logger.Debugf("Received handshake packet (legacy=%v) %+v", handshakePacket.IsLegacy(), handshakePacket)
 
+	var loginStartPacket *protocol.LoginStartPacket
+	if pkg, ok := handshakePacket.(*protocol.HandshakePacket); ok && h.config.ReadLoginStart &&
+		(pkg.NextState == protocol.HandshakeNextStateLogin || pkg.NextState == protocol.HandshakeNextStateTransfer) {
+		loginStartPacket, err = protocol.ReadLoginStartPacket(connReadWriter, pkg.Protocol)
+		if err != nil {
+			deadlineTimer.Stop()
+			if !handshakeTimeout {
+				h.logger.Errorf("Failed to read login start packet from client: %v", err)
+				metrics.HandshakeFailuresTotal.Inc(metrics.HandshakeFailureBadLoginStart)
+			} else {
+				metrics.HandshakeFailuresTotal.Inc(metrics.HandshakeFailureTimeout)
+			}
+			outcome = metrics.OutcomeHandshakeFailed
+			return
+		}
+		h.logger.Debugf("Received login start packet %+v", loginStartPacket)
+	}
+	deadlineTimer.Stop()
+
+	sendLegacyKick := func(status *protocol.LegacyStatus) {
+		pkg := handshakePacket.(*protocol.LegacyServerListPingPacket)
+		kickPacket := protocol.LegacyKickPacket{Reason: status.Format(pkg.Variant)}
+		if err := protocol.WritePacket(connReadWriter, &kickPacket); err != nil {
+			h.logger.Errorf("Failed to send legacy kick packet to client: %v", err)
+		}
+		h.logger.Debugf("Sent legacy kick packet %+v", kickPacket)
+		if tcpConn, ok := h.clientConn.(*net.TCPConn); ok {
+			_ = tcpConn.CloseWrite()
+		}
+	}
 	disconnectWithMessage := func(messageJson string) {
+		if pkg, ok := handshakePacket.(*protocol.LegacyServerListPingPacket); ok && len(messageJson) > 0 {
+			// legacy clients can only see the message in the server list
+			sendLegacyKick(&protocol.LegacyStatus{
+				Protocol: int32(pkg.Protocol),
+				Motd:     protocol.TextComponentToPlain(messageJson),
+			})
+		}
 		if pkg, ok := handshakePacket.(*protocol.HandshakePacket); ok {
-			if pkg.NextState == protocol.HandshakeNextStateLogin && len(messageJson) > 0 {
+			if (pkg.NextState == protocol.HandshakeNextStateLogin || pkg.NextState == protocol.HandshakeNextStateTransfer) && len(messageJson) > 0 {
 				disconnectPacket := protocol.DisconnectPacket{Reason: messageJson}
 				err := protocol.WritePacket(connReadWriter, &disconnectPacket)
 				if err != nil {
@@ -106,6 +238,29 @@ func (h *ConnectionHandler) handleConnection() {
 		closeClientConn()
 	}
 
+	if h.router.draining.Load() {
+		if messageJson := h.config.Shutdown.GetMessageJson(); len(messageJson) > 0 {
+			h.logger.Infof("Disconnecting the client since the router is shutting down")
+			disconnectWithMessage(messageJson)
+			outcome = metrics.OutcomeShuttingDown
+			return
+		}
+	}
+
+	if realIpTrusted {
+		if !h.decodeRealIp(handshakePacket) {
+			metrics.HandshakeFailuresTotal.Inc(metrics.HandshakeFailureBadRealIp)
+			outcome = metrics.OutcomeHandshakeFailed
+			return
+		}
+		// the slot of the total limit is taken again with the per ip limits
+		releaseLimit()
+		releaseLimit = nil
+		if !checkClient(handshakePacket) {
+			return
+		}
+	}
+
 	// ============================== Do Route ==============================
 
 	rawHostname := *handshakePacket.GetHostname()
@@ -114,47 +269,176 @@ func (h *ConnectionHandler) handleConnection() {
 	hostname = strings.Split(hostname, "\x00")[0] // forge client stuff
 	hostnameTail := rawHostname[len(hostname):]
 
-	route := h.RouteFor(hostname, port)
+	query := &config.RouteQuery{
+		Hostname:  hostname,
+		Port:      port,
+		NextState: protocol.HandshakeNextStateStatus,
+	}
+	if pkg, ok := handshakePacket.(*protocol.HandshakePacket); ok {
+		query.NextState = pkg.NextState
+		query.Protocol = pkg.Protocol
+	} else {
+		query.IsLegacy = true
+	}
+	if loginStartPacket != nil {
+		query.Username = loginStartPacket.Name
+	}
+	match := h.RouteFor(query)
 	msg := "Address in handshake packet"
-	if handshakePacket.IsLegacy() {
-		msg += " (legacy)"
+	if pkg, ok := handshakePacket.(*protocol.LegacyServerListPingPacket); ok {
+		msg += fmt.Sprintf(" (legacy %s)", pkg.Variant)
 	}
 	msg += fmt.Sprintf(": %s:%d", hostname, port)
 	if len(hostnameTail) > 0 {
 		msg += fmt.Sprintf(", hostname tail len %d", len(hostnameTail))
 	}
+	if loginStartPacket != nil {
+		msg += fmt.Sprintf(", player %s", loginStartPacket.Name)
+		if loginStartPacket.Uuid != nil {
+			msg += fmt.Sprintf(" (%s)", loginStartPacket.Uuid)
+		}
+	}
 	h.logger.Infof(msg)
+	h.info.update(func(c *connectionInfo) {
+		c.hostname, c.port, c.protocol = hostname, port, query.Protocol
+		c.nextState = nextStateName(query)
+		if loginStartPacket != nil {
+			c.player = loginStartPacket.Name
+		}
+	})
 
-	if route == nil {
+	if loginStartPacket != nil {
+		if refused, messageJson := config.CheckPlayer(h.config.AllowedPlayers, h.config.BannedPlayers, loginStartPacket.Name, loginStartPacket.Uuid); refused {
+			h.logger.Infof("Reject player %s by the global player lists", loginStartPacket.Name)
+			disconnectWithMessage(messageJson)
+			outcome = metrics.OutcomePlayerRefused
+			return
+		}
+	}
+
+	if match == nil {
 		h.logger.Infof("Cannot found any endpoint for %s:%d, closing connection", hostname, port)
+		outcome = metrics.OutcomeNoRoute
 		return
 	}
+	route := match.Route
 
+	h.logger = h.logger.WithField("route", route.Name)
 	h.logger.Infof("Selected route '%s' with action '%s'", route.Name, route.Action)
+	metrics.RouteConnectionsTotal.Inc(route.Name, string(route.Action))
+	routeTable := h.listener.GetRoutes().Name
+	h.info.update(func(c *connectionInfo) {
+		c.route, c.routeTable = route.Name, routeTable
+	})
+
+	if !h.checkClientIp(route) {
+		outcome = metrics.OutcomeIpFiltered
+		return
+	}
+
+	if match.UnsupportedVersion {
+		h.logger.Infof("Reject connection since protocol version %d is not supported by the route", query.Protocol)
+		disconnectWithMessage(route.GetUnsupportedVersionMessageJson())
+		outcome = metrics.OutcomeUnsupportedVersion
+		return
+	}
+
+	if loginStartPacket != nil {
+		if refused, messageJson := config.CheckPlayer(route.AllowedPlayers, route.BannedPlayers, loginStartPacket.Name, loginStartPacket.Uuid); refused {
+			h.logger.Infof("Reject player %s by the player lists of the route", loginStartPacket.Name)
+			disconnectWithMessage(messageJson)
+			outcome = metrics.OutcomePlayerRefused
+			return
+		}
+	}
 
 	if route.Action == config.Reject {
 		h.logger.Infof("Reject connection by route config")
 		disconnectWithMessage(route.GetRejectMessageJson())
+		outcome = metrics.OutcomeRejected
 		return
 	}
 
+	if len(route.Mimic) > 0 {
+		mimic, err := match.ExpandAddress(route.Mimic)
+		host, portStr := "", ""
+		if err == nil {
+			host, portStr, err = net.SplitHostPort(mimic)
+		}
+		if err == nil {
+			port, err := strconv.Atoi(portStr)
+			if err == nil {
+				*handshakePacket.GetHostname() = host + hostnameTail
+				*handshakePacket.GetPort() = uint16(port)
+				h.logger.Infof("Modified address in handshake packet to %s:%d", host, port)
+			} else {
+				h.logger.Errorf("Invalid port %s: %v", portStr, err)
+			}
+		} else {
+			h.logger.Errorf("Invalid mimic address %s: %v", route.Mimic, err)
+		}
+	}
+
+	if route.BungeeCordForwarding && loginStartPacket != nil {
+		// the uuid claimed by the client is not verified, so it's only used if the route explicitly trusts it
+		uuid := protocol.OfflinePlayerUUID(loginStartPacket.Name)
+		if route.BungeeCordTrustClientUuid && loginStartPacket.Uuid != nil {
+			uuid = *loginStartPacket.Uuid
+		}
+		if clientIp := addrIp(h.clientAddr); clientIp != nil {
+			forwardingHostname, err := bungeeCordHostname(*handshakePacket.GetHostname(), clientIp, uuid, route.BungeeGuardToken)
+			if err != nil {
+				h.logger.Errorf("Failed to create the BungeeCord forwarding data: %v", err)
+				outcome = metrics.OutcomeForwardFailed
+				return
+			}
+			*handshakePacket.GetHostname() = forwardingHostname
+			h.logger.Debugf("Added BungeeCord forwarding data to the handshake, ip %s, uuid %s", clientIp, uuid)
+		} else {
+			h.logger.Warnf("Cannot get the ip of client address %s, BungeeCord forwarding data is not added", h.clientAddr)
+		}
+	}
+
 	// ============================== Connect to Target ==============================
 
-	target, err := h.resolveTarget(route)
-	if err != nil {
-		h.logger.Errorf("Failed to resolve target for route '%s': %v", route.Name, err)
+	dialAddresses := h.router.selectTargets(route, match, h.logger)
+	onDialFailed := func() {
+		outcome = metrics.OutcomeDialFailed
+		if pkg, ok := handshakePacket.(*protocol.HandshakePacket); ok && pkg.NextState == protocol.HandshakeNextStateStatus && route.OfflineStatus != nil {
+			h.logger.Infof("Responding with the offline status")
+			h.serveStatus(connReadWriter, route.OfflineStatus.GetStatusJson(pkg.Protocol))
+			closeClientConn()
+			return
+		}
+		if pkg, ok := handshakePacket.(*protocol.LegacyServerListPingPacket); ok && route.OfflineStatus != nil {
+			h.logger.Infof("Responding with the offline status (legacy)")
+			sendLegacyKick(route.OfflineStatus.GetLegacyStatus(int32(pkg.Protocol)))
+			closeClientConn()
+			return
+		}
+		disconnectWithMessage(route.GetDialFailMessageJson())
+	}
+	if len(dialAddresses) == 0 {
+		h.logger.Warnf("No healthy target available for route '%s'", route.Name)
+		onDialFailed()
 		return
 	}
 
-	h.logger.Infof("Dialing to target %s", target)
-	t := time.Now()
-	targetConn, err := net.DialTimeout("tcp", target, route.Timeout)
-	h.logger.Debugf("Dial cost %dms", time.Now().Sub(t).Milliseconds())
-	if err != nil {
-		h.logger.Errorf("Dial to target %s failed: %v", target, err)
-		disconnectWithMessage(route.GetDialFailMessageJson())
+	if pkg, ok := handshakePacket.(*protocol.HandshakePacket); ok && pkg.NextState == protocol.HandshakeNextStateStatus && route.StatusProxy != nil {
+		outcome = metrics.OutcomeStatusProxied
+		h.proxyStatus(connReadWriter, route, pkg, dialAddresses, onDialFailed)
+		return
+	}
+
+	targetConn, targetAddress, releaseTarget := h.dialTargets(route, dialAddresses)
+	if targetConn == nil {
+		onDialFailed()
 		return
 	}
+	h.info.update(func(c *connectionInfo) {
+		c.target = targetAddress
+	})
+	defer releaseTarget()
 	closeTargetConn := onceFunc(func() {
 		h.closeConnection("target", targetConn)
 	})
@@ -162,65 +446,50 @@ func (h *ConnectionHandler) handleConnection() {
 
 	// ============================== Write Handshake Packet etc. ==============================
 
+	outcome = metrics.OutcomeForwardFailed
+
 	if 1 <= route.ProxyProtocol && route.ProxyProtocol <= 2 {
-		isIpv4 := func(addr net.Addr) bool {
-			tcpAddr, err := net.ResolveTCPAddr("tcp", addr.String())
+		proxyProtocolHeader := newProxyProtocolHeader(route.ProxyProtocol, h.clientAddr, targetConn.RemoteAddr())
+		if proxyProtocolHeader.Command.IsLocal() {
+			h.logger.Warnf("Cannot tell the client address %s in the proxy protocol header, sending a LOCAL header", h.clientAddr)
+		}
+		if route.ProxyProtocolTlvs != nil {
+			uniqueId, err := setProxyProtocolTlvs(proxyProtocolHeader, route.ProxyProtocolTlvs, hostname, route.Name)
 			if err != nil {
-				log.Fatalf("Failed to resolve tcp address %s: %v", addr.String(), err)
+				h.logger.Errorf("Failed to set proxy protocol TLVs: %v", err)
+				return
+			}
+			if len(uniqueId) > 0 {
+				h.logger.Debugf("Proxy protocol unique id: %s", uniqueId)
 			}
-			return tcpAddr.IP.To4() != nil
-		}
-		clientAddr := h.clientConn.RemoteAddr()
-		targetAddr := targetConn.RemoteAddr()
-		clientIs4 := isIpv4(clientAddr)
-		targetIs4 := isIpv4(targetAddr)
-
-		var transportProtocol proxyproto.AddressFamilyAndProtocol
-		if clientIs4 && targetIs4 {
-			transportProtocol = proxyproto.TCPv4
-		} else if !clientIs4 && !targetIs4 {
-			transportProtocol = proxyproto.TCPv6
-		} else {
-			h.logger.Errorf("Mixed use of IPv4 and IPv6, cannot create a HAProxy protocol header. clientAddr: %s, targetAddr: %s", clientAddr, targetAddr)
-		}
-		proxyProtocolHeader := &proxyproto.Header{
-			Version:           byte(route.ProxyProtocol),
-			Command:           proxyproto.PROXY,
-			TransportProtocol: transportProtocol,
-			SourceAddr:        clientAddr,
-			DestinationAddr:   targetAddr,
 		}
-		if _, err := proxyProtocolHeader.WriteTo(targetConn); err != nil {
+		if err := writeProxyProtocolHeader(targetConn, proxyProtocolHeader); err != nil {
 			h.logger.Errorf("Failed to write proxy protocol header to target: %v", err)
 			return
 		}
 	}
 
-	if len(route.Mimic) > 0 {
-		host, portStr, err := net.SplitHostPort(route.Mimic)
-		if err == nil {
-			port, err := strconv.Atoi(portStr)
-			if err == nil {
-				*handshakePacket.GetHostname() = host + hostnameTail
-				*handshakePacket.GetPort() = uint16(port)
-				h.logger.Infof("Modified address in handshake packet to %s:%d", host, port)
-			} else {
-				h.logger.Errorf("Invalid port %s: %v", portStr, err)
-			}
-		} else {
-			h.logger.Errorf("Invalid mimic address %s: %v", route.Mimic, err)
-		}
-	}
-
 	if err := protocol.WritePacket(protocol.NewBufferReadWriter(targetConn), handshakePacket); err != nil {
 		h.logger.Errorf("Failed to write handshake packet to target: %v", err)
 		return
 	}
+	if loginStartPacket != nil {
+		// replay the login start packet that has been consumed
+		if err := protocol.WritePacket(protocol.NewBufferReadWriter(targetConn), loginStartPacket); err != nil {
+			h.logger.Errorf("Failed to write login start packet to target: %v", err)
+			return
+		}
+	}
 
 	// ============================== Start Forwarding ==============================
 
 	h.logger.Infof("Start forwarding")
-	h.forward(h.clientConn, targetConn, func() {
+	outcome = metrics.OutcomeForwarded
+	outcomeCounted = true // counted right away, since forwarding might last for hours
+	metrics.ConnectionsTotal.Inc(outcome)
+	metrics.ActiveForwards.Inc(route.Name)
+	defer metrics.ActiveForwards.Dec(route.Name)
+	h.forward(route, h.clientConn, targetConn, func() {
 		closeClientConn()
 		closeTargetConn()
 	})
@@ -228,24 +497,24 @@ func (h *ConnectionHandler) handleConnection() {
 	h.logger.Infof("Client connection end")
 }
 
-func (h *ConnectionHandler) forward(source net.Conn, target net.Conn, closeConnectionFunc func()) {
+func (h *ConnectionHandler) forward(route *config.Route, source net.Conn, target net.Conn, closeConnectionFunc func()) {
 	doneChan := make(chan struct{})
 	var doneFlag int32
 
-	singleForward := func(desc string, s net.Conn, t net.Conn) {
+	singleForward := func(desc string, direction string, s net.Conn, t net.Conn, counter *atomic.Int64) {
 		defer func() {
 			doneChan <- struct{}{}
 		}()
 		h.logger.Debugf("Forward start for %s", desc)
-		n, err := io.Copy(t, s)
-		if err != nil && atomic.LoadInt32(&doneFlag) == 0 {
+		n, err := io.Copy(&countingWriter{writer: t, counter: counter, route: route.Name, direction: direction}, s)
+		if err != nil && atomic.LoadInt32(&doneFlag) == 0 && !h.info.kicked.Load() {
 			h.logger.Warningf("Forward error for %s: %v", desc, err)
 		}
 		h.logger.Debugf("Forward end for %s, bytes transfered = %d", desc, n)
 	}
 
-	go singleForward("client -> target", source, target)
-	go singleForward("client <- target", target, source)
+	go singleForward("client -> target", metrics.DirectionServerbound, source, target, &h.info.serverbound)
+	go singleForward("client <- target", metrics.DirectionClientbound, target, source, &h.info.clientbound)
 
 	_ = <-doneChan
 	atomic.StoreInt32(&doneFlag, 1)
@@ -253,42 +522,164 @@ func (h *ConnectionHandler) forward(source net.Conn, target net.Conn, closeConne
 	_ = <-doneChan
 }
 
-// RouteFor might return nullable
-func (h *ConnectionHandler) RouteFor(hostname string, port uint16) *config.Route {
-	hostname = strings.TrimRight(hostname, ".") // domain name might have a tailing ".", remove that
-	address := fmt.Sprintf("%s:%d", hostname, port)
-	routeMap := h.config.GetRouteMap()
+// disconnectLimitedLogin disconnects the client with the message if it's a login attempt. If the handshake packet is not read yet (nil), it waits for it for a short time
+func (h *ConnectionHandler) disconnectLimitedLogin(handshakePacket protocol.IHandshakePacket, messageJson string) {
+	connReadWriter := protocol.NewBufferReadWriter(h.clientConn)
+	if handshakePacket == nil {
+		_ = h.clientConn.SetReadDeadline(time.Now().Add(limitedHandshakeMaxTimeWait))
+		var err error
+		if handshakePacket, err = protocol.ReadHandshakePacket(connReadWriter); err != nil {
+			return
+		}
+	}
+	if pkg, ok := handshakePacket.(*protocol.HandshakePacket); ok && (pkg.NextState == protocol.HandshakeNextStateLogin || pkg.NextState == protocol.HandshakeNextStateTransfer) {
+		disconnectPacket := protocol.DisconnectPacket{Reason: messageJson}
+		if err := protocol.WritePacket(connReadWriter, &disconnectPacket); err != nil {
+			h.logger.Errorf("Failed to send disconnect packet to client: %v", err)
+		}
+		if tcpConn, ok := h.clientConn.(*net.TCPConn); ok {
+			_ = tcpConn.CloseWrite()
+		}
+	}
+}
 
-	if route, ok := routeMap[strings.ToLower(address)]; ok {
-		h.logger.Debugf("Selected route '%s' for address %s", route.Name, address)
-		return route
+func (h *ConnectionHandler) writeAccessLog(outcome string) {
+	record := &accessRecord{
+		Time:             time.Now(),
+		ClientId:         h.id,
+		Listener:         h.listenerName,
+		Outcome:          outcome,
+		Duration:         time.Since(h.info.startTime).Seconds(),
+		ServerboundBytes: h.info.serverbound.Load(),
+		ClientboundBytes: h.info.clientbound.Load(),
+	}
+	if ip := addrIp(h.clientAddr); ip != nil {
+		record.ClientIp = ip.String()
 	}
-	if route, ok := routeMap[strings.ToLower(hostname)]; ok {
-		h.logger.Debugf("Selected route '%s' for hostname %s", route.Name, address)
-		return route
+	h.info.update(func(c *connectionInfo) {
+		record.Hostname, record.Port, record.Protocol, record.NextState = c.hostname, c.port, c.protocol, c.nextState
+		record.Player, record.Route, record.Target = c.player, c.route, c.target
+	})
+	h.router.writeAccessLog(record)
+}
+
+// checkClientIp checks the client ip against the ip filters of the route. Use the ip filters of the listener if route is nil
+func (h *ConnectionHandler) checkClientIp(route *config.Route) bool {
+	whitelist, blacklist := h.listener.GetIpFilters(route)
+	if whitelist == nil && blacklist == nil {
+		return true
+	}
+	ip := tcpRemoteIp(h.clientConn)
+	if h.realIp {
+		ip = addrIp(h.clientAddr)
+	}
+	if ip == nil || !config.CheckIp(ip, whitelist, blacklist) {
+		h.logger.Infof("Rejected since client ip %s is not allowed by the ip filters", ip)
+		return false
 	}
+	return true
+}
+
+// RouteFor might return nullable
+func (h *ConnectionHandler) RouteFor(query *config.RouteQuery) *config.RouteMatch {
+	address := fmt.Sprintf("%s:%d", query.Hostname, query.Port)
 
-	if defaultRoute := h.config.GetDefaultRoute(); defaultRoute != nil {
-		h.logger.Debugf("Selected default route for address %s", address)
-		return defaultRoute
+	routes := h.listener.GetRoutes()
+	candidates := routes.GetRouteMatcher().Candidates(query.Hostname, query.Port)
+	if defaultRoute := routes.GetDefaultRoute(); defaultRoute != nil {
+		candidates = append(candidates, &config.RouteMatch{Route: defaultRoute})
+	}
+	for _, match := range candidates {
+		route := match.Route
+		if !route.Accepts(query) {
+			h.logger.Debugf("Route '%s' matches address %s, but its filters do not accept the client", route.Name, address)
+			continue
+		}
+		if !route.AcceptsProtocol(query) {
+			if len(route.GetUnsupportedVersionMessageJson()) == 0 {
+				h.logger.Debugf("Route '%s' matches address %s, but it does not accept protocol version %d", route.Name, address, query.Protocol)
+				continue
+			}
+			// server list pings cannot show a disconnect message, so the route serves them as usual,
+			// and the server list shows the version of the route as incompatible
+			match.UnsupportedVersion = query.NextState != protocol.HandshakeNextStateStatus
+		}
+		h.logger.Debugf("Selected route '%s' for address %s", route.Name, address)
+		return match
 	}
 
 	h.logger.Debugf("No valid route for address %s", address)
 	return nil
 }
 
-func (h *ConnectionHandler) resolveTarget(route *config.Route) (string, error) {
-	if !strings.Contains(route.Target, ":") { // no port, might be an SRV record
+type dialTarget struct {
+	Address  string // with template vars expanded
+	Template string // as configured. Metrics are labelled with it, since the expanded address can be chosen by the client
+}
+
+// dialTargets tries the given targets in order, and returns the first successfully established connection.
+// The address of the connected target is returned as well. If all attempts fail, a nil connection is returned
+func (h *ConnectionHandler) dialTargets(route *config.Route, targets []dialTarget) (net.Conn, string, func()) {
+	for i, dt := range targets {
+		address := dt.Address
+		release := h.router.balancer.Acquire(address)
+
+		target, err := h.resolveTarget(address)
+		if err != nil {
+			h.logger.Errorf("Failed to resolve target %s for route '%s': %v", address, route.Name, err)
+			release()
+			continue
+		}
+
+		attempt := ""
+		if len(targets) > 1 {
+			attempt = fmt.Sprintf(" (attempt %d/%d)", i+1, len(targets))
+		}
+		h.logger.Infof("Dialing to target %s%s", target, attempt)
 		t := time.Now()
-		resolved, err := dns.ResolveSrv(route.Target, h.config.SrvLookupTimeout)
-		h.logger.Debugf("SRV Resolution for %s cost %dms", route.Target, time.Now().Sub(t).Milliseconds())
+		targetConn, err := net.DialTimeout("tcp", target, route.Timeout)
+		h.logger.Debugf("Dial cost %dms", time.Now().Sub(t).Milliseconds())
+		if err != nil {
+			h.logger.Errorf("Dial to target %s failed%s: %v", target, attempt, err)
+			metrics.DialFailuresTotal.Inc(dt.Template)
+			release()
+			continue
+		}
+		metrics.DialDuration.Observe(time.Since(t).Seconds(), dt.Template)
+		return targetConn, address, release
+	}
+	return nil, "", nil
+}
 
-		if err == nil {
-			return resolved, nil
-		} else {
-			h.logger.Debugf("Resolved SRV record for %s failed: %v", route.Target, err)
+func (h *ConnectionHandler) resolveTarget(target string) (string, error) {
+	return resolveTarget(target, h.config.SrvLookupTimeout, h.logger)
+}
+
+// decodeRealIp decodes the real client ip in the handshake hostname, and strips the encoded data from the hostname.
+// It returns false if the client should be rejected
+func (h *ConnectionHandler) decodeRealIp(handshakePacket protocol.IHandshakePacket) bool {
+	rawHostname := *handshakePacket.GetHostname()
+	payload := strings.Split(rawHostname, "\x00")[0] // keep the forge client stuff
+	data, err := h.listener.RealIp.Decode(payload, time.Now())
+	if errors.Is(err, config.ErrNoRealIp) {
+		if h.listener.RealIp.Required {
+			h.logger.Warnf("Rejected since the real ip is missing in the handshake")
+			return false
 		}
-		return fmt.Sprintf("%s:25565", route.Target), nil
+		return true
+	}
+	if err != nil {
+		h.logger.Warnf("Rejected since the real ip in the handshake is invalid: %v", err)
+		return false
 	}
-	return route.Target, nil
+
+	*handshakePacket.GetHostname() = data.Hostname + rawHostname[len(payload):]
+	h.logger.Infof("Real client address: %s (via %s)", data.ClientAddr, h.clientAddr)
+	h.clientAddr = data.ClientAddr
+	h.realIp = true
+	h.logger = h.logger.WithField("client_addr", data.ClientAddr.String())
+	h.info.update(func(c *connectionInfo) {
+		c.clientAddr = data.ClientAddr.String()
+	})
+	return true
 }
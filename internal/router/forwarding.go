@@ -0,0 +1,33 @@
+package router
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+)
+
+const bungeeGuardTokenProperty = "bungeeguard-token"
+
+// bungeeCordProperty is a game profile property in the BungeeCord forwarding data
+type bungeeCordProperty struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// bungeeCordHostname creates the handshake hostname with the BungeeCord ip forwarding data, i.e. "host\x00ip\x00uuid[\x00properties]".
+// The hostname tail of Forge clients is dropped, since backends with BungeeCord forwarding reject hostnames with extra parts
+func bungeeCordHostname(hostname string, clientIp net.IP, uuid protocol.UUID, bungeeGuardToken string) (string, error) {
+	host := strings.Split(hostname, "\x00")[0]
+	parts := []string{host, clientIp.String(), uuid.PlainString()}
+	if len(bungeeGuardToken) > 0 {
+		properties, err := json.Marshal([]bungeeCordProperty{{Name: bungeeGuardTokenProperty, Value: bungeeGuardToken}})
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, string(properties))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+)
+
+func TestBungeeCordHostname(t *testing.T) {
+	uuid, _ := protocol.ParseUUID("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+	for _, tc := range []struct {
+		name     string
+		hostname string
+		clientIp string
+		token    string
+		expected string
+	}{
+		{"no token", "mc.example.com", "1.2.3.4", "", "mc.example.com\x001.2.3.4\x00069a79f444e94726a5befca90e38aaf5"},
+		{"token", "mc.example.com", "1.2.3.4", "secret", "mc.example.com\x001.2.3.4\x00069a79f444e94726a5befca90e38aaf5\x00" + `[{"name":"bungeeguard-token","value":"secret"}]`},
+		{"ipv6 client", "mc.example.com", "2001:db8::1", "", "mc.example.com\x002001:db8::1\x00069a79f444e94726a5befca90e38aaf5"},
+		{"forge tail", "mc.example.com\x00FML3\x00", "1.2.3.4", "", "mc.example.com\x001.2.3.4\x00069a79f444e94726a5befca90e38aaf5"},
+		{"forge tail with token", "mc.example.com\x00FML2\x00", "1.2.3.4", `"quoted"`, "mc.example.com\x001.2.3.4\x00069a79f444e94726a5befca90e38aaf5\x00" + `[{"name":"bungeeguard-token","value":"\"quoted\""}]`},
+	} {
+		actual, err := bungeeCordHostname(tc.hostname, net.ParseIP(tc.clientIp), uuid, tc.token)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		} else if actual != tc.expected {
+			t.Errorf("%s: expected %q, found %q", tc.name, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+type targetHealth struct {
+	healthy   bool
+	successes int // consecutive successful checks
+	failures  int // consecutive failed checks
+}
+
+// healthCheckTarget is the key of a health state. The same address is checked once per proxy protocol version,
+// since a target might only answer the pings with the right proxy protocol header
+type healthCheckTarget struct {
+	address       string // the address in the config, might be without port
+	proxyProtocol int
+}
+
+// healthChecker periodically status-pings route targets, and marks them up or down
+type healthChecker struct {
+	router *MinecraftRouter
+	mutex  sync.RWMutex
+	states map[healthCheckTarget]*targetHealth
+}
+
+func newHealthChecker(router *MinecraftRouter) *healthChecker {
+	return &healthChecker{
+		router: router,
+		states: make(map[healthCheckTarget]*targetHealth),
+	}
+}
+
+// IsHealthy returns false only if the target is known to be down, when connected with the proxy protocol setting of the route.
+// Templated targets are never checked, so they are always considered healthy
+func (c *healthChecker) IsHealthy(address string, route *config.Route) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	state, ok := c.states[healthCheckTarget{address: address, proxyProtocol: route.ProxyProtocol}]
+	return !ok || state.healthy
+}
+
+// Run checks the targets periodically until stopCh is closed. The health check config is reloaded before each round
+func (c *healthChecker) Run(stopCh <-chan struct{}) {
+	enabled := false
+	for {
+		cfg := c.router.GetConfig().HealthCheck
+		if cfg.Enabled != enabled {
+			enabled = cfg.Enabled
+			if enabled {
+				log.Infof("Health checker started, interval %s", cfg.Interval)
+			} else {
+				log.Infof("Health checker stopped")
+				c.mutex.Lock()
+				c.states = make(map[healthCheckTarget]*targetHealth) // so no target is stuck in the down state
+				c.mutex.Unlock()
+			}
+		}
+		if enabled {
+			c.checkAll()
+		}
+
+		timer := time.NewTimer(cfg.Interval)
+		select {
+		case <-stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func collectHealthCheckTargets(cfg *config.Config) []healthCheckTarget {
+	var targets []healthCheckTarget
+	visited := make(map[healthCheckTarget]bool)
+	add := func(address string, route *config.Route) {
+		target := healthCheckTarget{address: address, proxyProtocol: route.ProxyProtocol}
+		if strings.Contains(address, "${") || visited[target] {
+			return // templated targets are only known after routing
+		}
+		visited[target] = true
+		targets = append(targets, target)
+	}
+	for _, table := range cfg.GetRouteTables() {
+		for i := range table.Routes {
+			route := &table.Routes[i]
+			if route.Action != config.Forward {
+				continue
+			}
+			for _, target := range route.Targets {
+				add(target.Address, route)
+			}
+			for _, fallback := range route.Fallbacks {
+				add(fallback, route)
+			}
+		}
+	}
+	return targets
+}
+
+func (c *healthChecker) checkAll() {
+	cfg := c.router.GetConfig()
+	targets := collectHealthCheckTargets(cfg)
+
+	results := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target healthCheckTarget) {
+			defer wg.Done()
+			results[i] = c.check(target)
+		}(i, target)
+	}
+	wg.Wait()
+	c.update(targets, results, &cfg.HealthCheck)
+}
+
+// update applies the check results of the targets to their states, and drops the states of targets no longer checked
+func (c *healthChecker) update(targets []healthCheckTarget, results []error, cfg *config.HealthCheck) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	alive := make(map[healthCheckTarget]bool)
+	for i, target := range targets {
+		alive[target] = true
+		state, ok := c.states[target]
+		if !ok {
+			state = &targetHealth{healthy: true}
+			c.states[target] = state
+		}
+
+		if err := results[i]; err == nil {
+			state.successes++
+			state.failures = 0
+			if !state.healthy && state.successes >= cfg.Rise {
+				state.healthy = true
+				log.Infof("Target %s is up", target.address)
+			}
+		} else {
+			state.failures++
+			state.successes = 0
+			log.Debugf("Health check for target %s failed (%d/%d): %v", target.address, state.failures, cfg.Fall, err)
+			if state.healthy && state.failures >= cfg.Fall {
+				state.healthy = false
+				log.Warnf("Target %s is down: %v", target.address, err)
+			}
+		}
+	}
+	for target := range c.states {
+		if !alive[target] {
+			delete(c.states, target)
+		}
+	}
+}
+
+func (c *healthChecker) check(target healthCheckTarget) error {
+	cfg := c.router.GetConfig()
+	address, err := resolveTarget(target.address, cfg.SrvLookupTimeout, log.WithField("health_check", target.address))
+	if err != nil {
+		return err
+	}
+	handshake, err := newStatusPingHandshake(address)
+	if err != nil {
+		return err
+	}
+	_, err = fetchStatus(address, handshake, target.proxyProtocol, cfg.HealthCheck.Timeout)
+	return err
+}
@@ -0,0 +1,101 @@
+package router
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+)
+
+func TestHealthCheckerRiseFall(t *testing.T) {
+	c := newHealthChecker(NewMinecraftRouter(&config.Config{}))
+	cfg := &config.HealthCheck{Rise: 2, Fall: 3}
+	route := &config.Route{}
+	target := healthCheckTarget{address: "10.0.0.1:25565"}
+	targets := []healthCheckTarget{target}
+	down := errors.New("connection refused")
+
+	for i, step := range []struct {
+		result  error
+		healthy bool
+	}{
+		{nil, true},
+		{down, true},
+		{down, true},
+		{down, false}, // the 3rd consecutive failure
+		{nil, false},
+		{down, false}, // a failure resets the successes
+		{nil, false},
+		{nil, true}, // the 2nd consecutive success
+		{down, true},
+	} {
+		c.update(targets, []error{step.result}, cfg)
+		if healthy := c.IsHealthy(target.address, route); healthy != step.healthy {
+			t.Fatalf("Step %d: expected healthy=%v, found %v", i, step.healthy, healthy)
+		}
+	}
+
+	// targets no longer checked are forgotten, and considered healthy
+	c.update(targets, []error{down}, cfg)
+	c.update(targets, []error{down}, cfg)
+	c.update(nil, nil, cfg)
+	if !c.IsHealthy(target.address, route) || len(c.states) != 0 {
+		t.Errorf("States of removed targets should be dropped, found %v", c.states)
+	}
+}
+
+func TestHealthCheckerProxyProtocol(t *testing.T) {
+	c := newHealthChecker(NewMinecraftRouter(&config.Config{}))
+	cfg := &config.HealthCheck{Rise: 1, Fall: 1}
+	plain := healthCheckTarget{address: "10.0.0.1:25565"}
+	proxied := healthCheckTarget{address: "10.0.0.1:25565", proxyProtocol: 2}
+
+	// the target only answers pings with the proxy protocol header
+	c.update([]healthCheckTarget{plain, proxied}, []error{errors.New("bad handshake"), nil}, cfg)
+	if c.IsHealthy("10.0.0.1:25565", &config.Route{}) {
+		t.Errorf("Target should be down for routes without proxy protocol")
+	}
+	if !c.IsHealthy("10.0.0.1:25565", &config.Route{ProxyProtocol: 2}) {
+		t.Errorf("Target should be up for routes with proxy protocol 2")
+	}
+	if !c.IsHealthy("10.0.0.1:25565", &config.Route{ProxyProtocol: 1}) {
+		t.Errorf("Target not checked with proxy protocol 1 should be considered healthy")
+	}
+}
+
+func TestCollectHealthCheckTargets(t *testing.T) {
+	cfg := newTestConfig(t, `
+listen: 0.0.0.0:7777
+routes:
+  - name: plain
+    matches: [a.example.com]
+    targets: [10.0.0.1:25565, 10.0.0.2:25565]
+    fallbacks: [10.0.0.1:25565]
+  - name: proxied
+    matches: [b.example.com]
+    target: 10.0.0.1:25565
+    proxy_protocol: 2
+  - name: templated
+    matches: ['re:^([a-z0-9]+)\.c\.example\.com$']
+    target: ${1}.internal:25565
+    fallbacks: [10.0.0.3:25565]
+  - name: rejected
+    matches: [d.example.com]
+    action: reject
+`)
+	expected := map[healthCheckTarget]bool{
+		{address: "10.0.0.1:25565"}:                   true,
+		{address: "10.0.0.2:25565"}:                   true,
+		{address: "10.0.0.1:25565", proxyProtocol: 2}: true,
+		{address: "10.0.0.3:25565"}:                   true,
+	}
+	targets := collectHealthCheckTargets(cfg)
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %d targets, found %v", len(expected), targets)
+	}
+	for _, target := range targets {
+		if !expected[target] {
+			t.Errorf("Unexpected target %+v", target)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	limiterSweepInterval = time.Minute
+	throttleLogWindow    = time.Minute
+)
+
+type limitReason string
+
+const (
+	limitNone         limitReason = ""
+	limitRate         limitReason = "connection rate limit exceeded"
+	limitConnsPerIp   limitReason = "max connections per ip exceeded"
+	limitConnsInTotal limitReason = "max connections in total exceeded"
+)
+
+// throttleLog makes throttling logged at most once per throttleLogWindow
+type throttleLog struct {
+	loggedAt   time.Time
+	suppressed int
+}
+
+func (t *throttleLog) log(now time.Time, what string, reason limitReason) {
+	if now.Sub(t.loggedAt) < throttleLogWindow {
+		t.suppressed++
+		return
+	}
+	if t.suppressed > 0 {
+		log.Warnf("Throttling connections from %s: %s, %d more throttled connections since the last log", what, reason, t.suppressed)
+	} else {
+		log.Warnf("Throttling connections from %s: %s", what, reason)
+	}
+	t.loggedAt = now
+	t.suppressed = 0
+}
+
+type ipLimitState struct {
+	tokens     float64
+	refilledAt time.Time
+	conns      int
+	rejecting  bool // if an over-limit connection of the ip is waiting for its handshake, to be sent the limit message
+	throttle   throttleLog
+}
+
+// connectionLimiter limits the connection rate per ip with token buckets, and the concurrent connections per ip and in total
+type connectionLimiter struct {
+	mutex      sync.Mutex
+	states     map[string]*ipLimitState // ip key -> state
+	totalConns int
+	throttle   throttleLog // for limitConnsInTotal
+	lastSweep  time.Time
+}
+
+func newConnectionLimiter() *connectionLimiter {
+	return &connectionLimiter{
+		states:    make(map[string]*ipLimitState),
+		lastSweep: time.Now(),
+	}
+}
+
+// ipLimitKey returns the key of the ip for limiting. IPv6 addresses are masked by the given prefix length
+func ipLimitKey(ip net.IP, ipv6Prefix int) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(ipv6Prefix, 128)), ipv6Prefix)
+}
+
+// Acquire checks the limits for a new connection from the given ip.
+// If the connection is allowed, limitNone is returned, and release needs to be called when the connection ends
+func (l *connectionLimiter) Acquire(ip net.IP, cfg *config.RateLimit) (release func(), reason limitReason) {
+	if cfg.Rate <= 0 && cfg.MaxConnsPerIp <= 0 && cfg.MaxConns <= 0 {
+		return func() {}, limitNone
+	}
+
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) >= limiterSweepInterval {
+		l.lastSweep = now
+		for k, s := range l.states {
+			// remove states of idle ips whose token buckets are refilled
+			if s.conns == 0 && !s.rejecting && (cfg.Rate <= 0 || s.tokens+now.Sub(s.refilledAt).Seconds()*cfg.Rate >= float64(cfg.Burst)) && now.Sub(s.throttle.loggedAt) >= throttleLogWindow {
+				delete(l.states, k)
+			}
+		}
+	}
+
+	if cfg.MaxConns > 0 && l.totalConns >= cfg.MaxConns {
+		l.throttle.log(now, "all ips", limitConnsInTotal)
+		return nil, limitConnsInTotal
+	}
+
+	key := ipLimitKey(ip, cfg.Ipv6Prefix)
+	state, ok := l.states[key]
+	if !ok {
+		state = &ipLimitState{tokens: float64(cfg.Burst), refilledAt: now}
+		l.states[key] = state
+	}
+
+	if cfg.MaxConnsPerIp > 0 && state.conns >= cfg.MaxConnsPerIp {
+		state.throttle.log(now, key, limitConnsPerIp)
+		return nil, limitConnsPerIp
+	}
+	if cfg.Rate > 0 {
+		state.tokens += now.Sub(state.refilledAt).Seconds() * cfg.Rate
+		if state.tokens > float64(cfg.Burst) {
+			state.tokens = float64(cfg.Burst)
+		}
+		state.refilledAt = now
+		if state.tokens < 1 {
+			state.throttle.log(now, key, limitRate)
+			return nil, limitRate
+		}
+		state.tokens--
+	}
+
+	state.conns++
+	l.totalConns++
+	return onceFunc(func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		state.conns--
+		l.totalConns--
+	}), limitNone
+}
+
+// AcquireTotal only checks the limit of connections in total, for connections whose client ip is not known yet.
+// If the connection is allowed, limitNone is returned, and release needs to be called when the connection ends
+func (l *connectionLimiter) AcquireTotal(cfg *config.RateLimit) (release func(), reason limitReason) {
+	if cfg.MaxConns <= 0 {
+		return func() {}, limitNone
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.totalConns >= cfg.MaxConns {
+		l.throttle.log(time.Now(), "all ips", limitConnsInTotal)
+		return nil, limitConnsInTotal
+	}
+	l.totalConns++
+	return onceFunc(func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.totalConns--
+	}), limitNone
+}
+
+// AcquireRejection checks if an over-limit connection from the given ip can wait for its handshake, to be sent the limit message.
+// Each ip can have only one such connection at a time, and it counts as a connection in total.
+// If it's allowed, ok is true, and release needs to be called when the wait ends
+func (l *connectionLimiter) AcquireRejection(ip net.IP, cfg *config.RateLimit) (release func(), ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if cfg.MaxConns > 0 && l.totalConns >= cfg.MaxConns {
+		return nil, false
+	}
+	state, ok := l.states[ipLimitKey(ip, cfg.Ipv6Prefix)]
+	if !ok || state.rejecting {
+		return nil, false
+	}
+	state.rejecting = true
+	l.totalConns++
+	return onceFunc(func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		state.rejecting = false
+		l.totalConns--
+	}), true
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+)
+
+func TestLimiterRate(t *testing.T) {
+	l := newConnectionLimiter()
+	cfg := &config.RateLimit{Rate: 0.001, Burst: 2, Ipv6Prefix: 64}
+
+	for i := 0; i < 2; i++ {
+		if _, reason := l.Acquire(net.ParseIP("1.2.3.4"), cfg); reason != limitNone {
+			t.Fatalf("Connection %d should be allowed, found %s", i, reason)
+		}
+	}
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.4"), cfg); reason != limitRate {
+		t.Errorf("Connection should be rate limited, found %q", reason)
+	}
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.5"), cfg); reason != limitNone {
+		t.Errorf("Other ips should not be limited, found %q", reason)
+	}
+
+	// ipv6 addresses in the same /64 share the same bucket
+	for _, ip := range []string{"2001:db8::1", "2001:db8::2"} {
+		if _, reason := l.Acquire(net.ParseIP(ip), cfg); reason != limitNone {
+			t.Fatalf("Connection from %s should be allowed, found %s", ip, reason)
+		}
+	}
+	if _, reason := l.Acquire(net.ParseIP("2001:db8::3"), cfg); reason != limitRate {
+		t.Errorf("Connection should be rate limited, found %q", reason)
+	}
+	if _, reason := l.Acquire(net.ParseIP("2001:db8:0:1::1"), cfg); reason != limitNone {
+		t.Errorf("Other ipv6 prefixes should not be limited, found %q", reason)
+	}
+}
+
+func TestLimiterConcurrent(t *testing.T) {
+	l := newConnectionLimiter()
+	cfg := &config.RateLimit{MaxConnsPerIp: 1, MaxConns: 2, Ipv6Prefix: 64}
+
+	release, reason := l.Acquire(net.ParseIP("1.2.3.4"), cfg)
+	if reason != limitNone {
+		t.Fatalf("Connection should be allowed, found %s", reason)
+	}
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.4"), cfg); reason != limitConnsPerIp {
+		t.Errorf("Connection should be limited per ip, found %q", reason)
+	}
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.5"), cfg); reason != limitNone {
+		t.Errorf("Connection should be allowed, found %s", reason)
+	}
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.6"), cfg); reason != limitConnsInTotal {
+		t.Errorf("Connection should be limited in total, found %q", reason)
+	}
+
+	release()
+	release() // releasing twice is fine
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.4"), cfg); reason != limitNone {
+		t.Errorf("Connection should be allowed after release, found %q", reason)
+	}
+}
+
+func TestLimiterRejection(t *testing.T) {
+	l := newConnectionLimiter()
+	cfg := &config.RateLimit{MaxConnsPerIp: 1, MaxConns: 3, Ipv6Prefix: 64}
+
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.4"), cfg); reason != limitNone {
+		t.Fatalf("Connection should be allowed, found %s", reason)
+	}
+	release, ok := l.AcquireRejection(net.ParseIP("1.2.3.4"), cfg)
+	if !ok {
+		t.Fatalf("The first over-limit connection should wait for the handshake")
+	}
+	if _, ok := l.AcquireRejection(net.ParseIP("1.2.3.4"), cfg); ok {
+		t.Errorf("Only one over-limit connection per ip should wait for the handshake")
+	}
+
+	// the waiting connection counts as a connection in total
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.5"), cfg); reason != limitNone {
+		t.Fatalf("Connection should be allowed, found %s", reason)
+	}
+	if _, reason := l.Acquire(net.ParseIP("1.2.3.6"), cfg); reason != limitConnsInTotal {
+		t.Errorf("Connection should be limited in total, found %q", reason)
+	}
+
+	release()
+	if _, ok := l.AcquireRejection(net.ParseIP("1.2.3.4"), cfg); !ok {
+		t.Errorf("Over-limit connection should wait for the handshake after release")
+	}
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	"github.com/pires/go-proxyproto"
+)
+
+// newProxyProtocolHeader creates the proxy protocol header that tells the target server the client address.
+// If the client and the target use different address families, the IPv4 one is converted to an IPv4-mapped IPv6 address.
+// If the addresses are not tcp addresses, a header with the LOCAL command is returned
+func newProxyProtocolHeader(version int, clientAddr net.Addr, targetAddr net.Addr) *proxyproto.Header {
+	header := &proxyproto.Header{
+		Version:           byte(version),
+		Command:           proxyproto.LOCAL,
+		TransportProtocol: proxyproto.UNSPEC,
+	}
+	source, sourceOk := clientAddr.(*net.TCPAddr)
+	destination, destinationOk := targetAddr.(*net.TCPAddr)
+	if !sourceOk || !destinationOk || source.IP.To16() == nil || destination.IP.To16() == nil {
+		return header
+	}
+
+	header.Command = proxyproto.PROXY
+	if sourceIp, destinationIp := source.IP.To4(), destination.IP.To4(); sourceIp != nil && destinationIp != nil {
+		header.TransportProtocol = proxyproto.TCPv4
+		header.SourceAddr = &net.TCPAddr{IP: sourceIp, Port: source.Port}
+		header.DestinationAddr = &net.TCPAddr{IP: destinationIp, Port: destination.Port}
+	} else {
+		header.TransportProtocol = proxyproto.TCPv6
+		header.SourceAddr = &net.TCPAddr{IP: source.IP.To16(), Port: source.Port}
+		header.DestinationAddr = &net.TCPAddr{IP: destination.IP.To16(), Port: destination.Port}
+	}
+	return header
+}
+
+// setProxyProtocolTlvs adds the TLVs in the route config to the v2 header, and returns the unique id if it's sent
+func setProxyProtocolTlvs(header *proxyproto.Header, tlvsConfig *config.ProxyProtocolTlvs, hostname string, routeName string) (string, error) {
+	var tlvs []proxyproto.TLV
+	uniqueId := ""
+	if tlvsConfig.Authority && len(hostname) > 0 {
+		tlvs = append(tlvs, proxyproto.TLV{Type: proxyproto.PP2_TYPE_AUTHORITY, Value: []byte(hostname)})
+	}
+	if tlvsConfig.UniqueId {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to generate unique id: %v", err)
+		}
+		uniqueId = hex.EncodeToString(buf)
+		tlvs = append(tlvs, proxyproto.TLV{Type: proxyproto.PP2_TYPE_UNIQUE_ID, Value: []byte(uniqueId)})
+	}
+	if tlvsConfig.RouteNameType != 0 {
+		tlvs = append(tlvs, proxyproto.TLV{Type: proxyproto.PP2Type(tlvsConfig.RouteNameType), Value: []byte(routeName)})
+	}
+	if err := header.SetTLVs(tlvs); err != nil {
+		return "", err
+	}
+	return uniqueId, nil
+}
+
+// writeProxyProtocolHeader writes the header to the writer.
+// Version 1 TCP6 headers are formatted here, since the library writes IPv4-mapped addresses in the IPv4 form, which is invalid in a TCP6 header
+func writeProxyProtocolHeader(writer io.Writer, header *proxyproto.Header) error {
+	if header.Version == 1 && header.TransportProtocol == proxyproto.TCPv6 {
+		source, destination := header.SourceAddr.(*net.TCPAddr), header.DestinationAddr.(*net.TCPAddr)
+		line := fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6String(source.IP), ipv6String(destination.IP), source.Port, destination.Port)
+		_, err := io.WriteString(writer, line)
+		return err
+	}
+	_, err := header.WriteTo(writer)
+	return err
+}
+
+// ipv6String formats the ip in the IPv6 form, e.g. "::ffff:1.2.3.4" for an IPv4-mapped address
+func ipv6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	"github.com/pires/go-proxyproto"
+)
+
+func TestProxyProtocolHeaderMixedFamilies(t *testing.T) {
+	clientAddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5555}
+	targetAddr := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 25565}
+
+	for _, version := range []int{1, 2} {
+		header := newProxyProtocolHeader(version, clientAddr, targetAddr)
+		if header.Command != proxyproto.PROXY || header.TransportProtocol != proxyproto.TCPv6 {
+			t.Fatalf("v%d: unexpected command %v and transport protocol %v", version, header.Command, header.TransportProtocol)
+		}
+		var buf bytes.Buffer
+		if err := writeProxyProtocolHeader(&buf, header); err != nil {
+			t.Fatalf("v%d: failed to write header: %v", version, err)
+		}
+		if version == 1 && buf.String() != "PROXY TCP6 ::ffff:1.2.3.4 2001:db8::1 5555 25565\r\n" {
+			t.Errorf("v1: unexpected header %q", buf.String())
+		}
+		parsed, err := proxyproto.Read(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("v%d: failed to parse the written header: %v", version, err)
+		}
+		if source := parsed.SourceAddr.(*net.TCPAddr); !source.IP.Equal(clientAddr.IP) || source.Port != clientAddr.Port {
+			t.Errorf("v%d: unexpected source address %s", version, source)
+		}
+	}
+
+	// not a tcp address, fallback to LOCAL
+	header := newProxyProtocolHeader(2, &net.UnixAddr{Name: "/tmp/smcr.sock", Net: "unix"}, targetAddr)
+	if !header.Command.IsLocal() {
+		t.Errorf("Expected a LOCAL header, found %v", header.Command)
+	}
+}
+
+func TestProxyProtocolTlvs(t *testing.T) {
+	header := newProxyProtocolHeader(2, &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5555}, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 25565})
+	uniqueId, err := setProxyProtocolTlvs(header, &config.ProxyProtocolTlvs{Authority: true, UniqueId: true, RouteNameType: 0xE0}, "mc.example.com", "survival")
+	if err != nil {
+		t.Fatalf("Failed to set TLVs: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeProxyProtocolHeader(&buf, header); err != nil {
+		t.Fatalf("Failed to write header: %v", err)
+	}
+	parsed, err := proxyproto.Read(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("Failed to parse the written header: %v", err)
+	}
+	tlvs, err := parsed.TLVs()
+	if err != nil {
+		t.Fatalf("Failed to parse TLVs: %v", err)
+	}
+	expected := map[proxyproto.PP2Type]string{
+		proxyproto.PP2_TYPE_AUTHORITY: "mc.example.com",
+		proxyproto.PP2_TYPE_UNIQUE_ID: uniqueId,
+		0xE0:                          "survival",
+	}
+	if len(tlvs) != len(expected) {
+		t.Fatalf("Expected %d TLVs, found %d", len(expected), len(tlvs))
+	}
+	for _, tlv := range tlvs {
+		if value, ok := expected[tlv.Type]; !ok || string(tlv.Value) != value {
+			t.Errorf("Unexpected TLV 0x%02X: %q", byte(tlv.Type), tlv.Value)
+		}
+	}
+}
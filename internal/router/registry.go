@@ -0,0 +1,150 @@
+package router
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/metrics"
+)
+
+// connectionInfo is the live state of a client connection, for the admin api and the access log
+type connectionInfo struct {
+	id        int
+	listener  string
+	startTime time.Time
+	closeFunc func()
+
+	mutex       sync.Mutex
+	clientAddr  string // might be replaced by the real ip decoded from the handshake
+	hostname    string // in the handshake packet
+	port        uint16
+	protocol    int32
+	nextState   string
+	player      string
+	route       string
+	routeTable  string // the name of the route table where the route is declared
+	target      string // the target address in the config
+	serverbound atomic.Int64
+	clientbound atomic.Int64
+	kicked      atomic.Bool
+}
+
+type connectionView struct {
+	Id               int       `json:"id"`
+	Listener         string    `json:"listener"`
+	ClientAddr       string    `json:"client_addr"`
+	Handshake        string    `json:"handshake,omitempty"`
+	Player           string    `json:"player,omitempty"`
+	Route            string    `json:"route,omitempty"`
+	RouteTable       string    `json:"route_table,omitempty"`
+	Target           string    `json:"target,omitempty"`
+	StartTime        time.Time `json:"start_time"`
+	ServerboundBytes int64     `json:"serverbound_bytes"`
+	ClientboundBytes int64     `json:"clientbound_bytes"`
+}
+
+func (c *connectionInfo) update(function func(c *connectionInfo)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	function(c)
+}
+
+func (c *connectionInfo) handshakeAddress() string {
+	if len(c.hostname) == 0 {
+		return ""
+	}
+	return net.JoinHostPort(c.hostname, strconv.Itoa(int(c.port)))
+}
+
+func (c *connectionInfo) view() connectionView {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return connectionView{
+		Id:               c.id,
+		Listener:         c.listener,
+		ClientAddr:       c.clientAddr,
+		Handshake:        c.handshakeAddress(),
+		Player:           c.player,
+		Route:            c.route,
+		RouteTable:       c.routeTable,
+		Target:           c.target,
+		StartTime:        c.startTime,
+		ServerboundBytes: c.serverbound.Load(),
+		ClientboundBytes: c.clientbound.Load(),
+	}
+}
+
+// kick closes the client connection. The handler then cleans up the rest
+func (c *connectionInfo) kick() {
+	c.kicked.Store(true)
+	c.closeFunc()
+}
+
+// connectionRegistry keeps track of all connections being handled
+type connectionRegistry struct {
+	mutex sync.RWMutex
+	conns map[int]*connectionInfo
+}
+
+func newConnectionRegistry() *connectionRegistry {
+	return &connectionRegistry{
+		conns: make(map[int]*connectionInfo),
+	}
+}
+
+func (r *connectionRegistry) Register(info *connectionInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.conns[info.id] = info
+}
+
+func (r *connectionRegistry) Unregister(id int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.conns, id)
+}
+
+// Get might return nil
+func (r *connectionRegistry) Get(id int) *connectionInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.conns[id]
+}
+
+// List returns all connections, ordered by id
+func (r *connectionRegistry) List() []*connectionInfo {
+	r.mutex.RLock()
+	conns := make([]*connectionInfo, 0, len(r.conns))
+	for _, info := range r.conns {
+		conns = append(conns, info)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].id < conns[j].id
+	})
+	return conns
+}
+
+// countingWriter counts the bytes written into the connection info and the metrics,
+// so the transferred bytes are visible during forwarding
+type countingWriter struct {
+	writer    io.Writer
+	counter   *atomic.Int64
+	route     string
+	direction string
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.counter.Add(int64(n))
+		metrics.TransferredBytesTotal.Add(float64(n), w.route, w.direction)
+	}
+	return n, err
+}
@@ -1,73 +1,230 @@
 package router
 
 import (
+	"fmt"
 	"github.com/Fallen-Breath/smcr/internal/config"
+	"github.com/Fallen-Breath/smcr/internal/metrics"
 	"github.com/pires/go-proxyproto"
 	log "github.com/sirupsen/logrus"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type MinecraftRouter struct {
-	stopCh chan struct{}
-	config *config.Config
+	stopCh        chan struct{} // closed when the shutdown starts
+	doneCh        chan struct{} // closed when all connections are closed
+	draining      atomic.Bool
+	config        atomic.Pointer[config.Config] // swapped on config reload. Connections keep using the config when they were accepted
+	balancer      *loadBalancer
+	healthChecker *healthChecker
+	statusCache   *statusCache
+	limiter       *connectionLimiter
+	connections   *connectionRegistry
+	accessLog     atomic.Pointer[accessLog]
 }
 
 func NewMinecraftRouter(config *config.Config) *MinecraftRouter {
 	r := &MinecraftRouter{
-		stopCh: make(chan struct{}),
-		config: config,
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+		balancer:    newLoadBalancer(),
+		statusCache: newStatusCache(),
+		limiter:     newConnectionLimiter(),
+		connections: newConnectionRegistry(),
 	}
+	r.config.Store(config)
+	r.updateAccessLog(config)
+	r.healthChecker = newHealthChecker(r)
 	return r
 }
 
-func (r *MinecraftRouter) Run() {
-	listener, err := net.Listen("tcp", r.config.Listen)
+func (r *MinecraftRouter) GetConfig() *config.Config {
+	return r.config.Load()
+}
+
+// SetConfig swaps in the new config for new connections. Existing connections are not affected
+func (r *MinecraftRouter) SetConfig(cfg *config.Config) {
+	oldCfg := r.config.Swap(cfg)
+	r.updateAccessLog(cfg)
+	r.balancer.ResetRoutes()
+	if listenerKeys(oldCfg) != listenerKeys(cfg) || oldCfg.MetricsListen != cfg.MetricsListen || oldCfg.AdminListen != cfg.AdminListen {
+		log.Warnf("Changes of listen, proxy_protocol, proxy_protocol_header_timeout, listener addresses, metrics_listen and admin_listen only take effect after a restart")
+	}
+	routeCount := 0
+	for _, table := range cfg.GetRouteTables() {
+		routeCount += len(table.Routes)
+	}
+	log.Infof("Config updated, %d routes loaded", routeCount)
+}
+
+// listenerKeys returns the listener properties that require a restart to change
+func listenerKeys(cfg *config.Config) string {
+	var keys []string
+	for _, listener := range cfg.GetListeners() {
+		keys = append(keys, fmt.Sprintf("%s|%s|%v|%s", listener.Name, listener.Listen, listener.ProxyProtocol, listener.ProxyProtocolHeaderTimeout))
+	}
+	return strings.Join(keys, ",")
+}
+
+func (r *MinecraftRouter) listen(listenerCfg *config.Listener) net.Listener {
+	listener, err := net.Listen("tcp", listenerCfg.Listen)
 	if err != nil {
-		log.Fatalf("Failed to listen on %s: %v", r.config.Listen, err)
+		log.Fatalf("Failed to listen on %s: %v", listenerCfg.Listen, err)
 	}
-	log.Infof("Listening on %s", r.config.Listen)
+	log.Infof("Listener '%s' listening on %s", listenerCfg.Name, listenerCfg.Listen)
 
-	if r.config.ProxyProtocol {
+	if listenerCfg.ProxyProtocol {
+		listenerName := listenerCfg.Name
 		listener = &proxyproto.Listener{
 			Listener: listener,
 			Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
-				return proxyproto.REQUIRE, nil
+				// use the current config, so changes of the trusted upstreams take effect on reload
+				ppCfg := &listenerCfg.ProxyProtocolConfig
+				if current := r.GetConfig().GetListener(listenerName); current != nil {
+					ppCfg = &current.ProxyProtocolConfig
+				}
+				return upstreamPolicy(ppCfg, upstream), nil
 			},
+			ReadHeaderTimeout: listenerCfg.ProxyProtocolHeaderTimeout,
 		}
-		log.Infof("ProxyProtocol listener enabled")
+		log.Infof("ProxyProtocol enabled for listener '%s'", listenerCfg.Name)
+	}
+	return listener
+}
+
+// upstreamPolicy returns the proxyproto policy for the upstream. It never fails, since a policy error aborts the accept loop
+func upstreamPolicy(ppCfg *config.ProxyProtocolConfig, upstream net.Addr) proxyproto.Policy {
+	var ip net.IP
+	if tcpAddr, ok := upstream.(*net.TCPAddr); ok {
+		ip = tcpAddr.IP
+	}
+	switch ppCfg.GetUpstreamPolicy(ip) {
+	case config.ProxyProtocolUse:
+		return proxyproto.USE
+	case config.ProxyProtocolIgnore:
+		return proxyproto.IGNORE
+	case config.ProxyProtocolReject:
+		return proxyproto.REJECT
+	default:
+		return proxyproto.REQUIRE
+	}
+}
+
+// rawRemoteAddr returns the address of the tcp peer. Unlike conn.RemoteAddr(), it does not wait for the proxy protocol header
+func rawRemoteAddr(conn net.Conn) net.Addr {
+	if ppConn, ok := conn.(*proxyproto.Conn); ok {
+		return ppConn.Raw().RemoteAddr()
+	}
+	return conn.RemoteAddr()
+}
+
+func (r *MinecraftRouter) Run() {
+	cfg := r.GetConfig()
+	listenerNames := make([]string, 0)
+	listeners := make([]net.Listener, 0)
+	for _, listenerCfg := range cfg.GetListeners() {
+		listenerNames = append(listenerNames, listenerCfg.Name)
+		listeners = append(listeners, r.listen(listenerCfg))
+	}
+
+	go r.healthChecker.Run(r.stopCh)
+	if len(cfg.MetricsListen) > 0 {
+		go metrics.Serve(cfg.MetricsListen, r.doneCh)
+	}
+	if len(cfg.AdminListen) > 0 {
+		go r.serveAdmin(cfg.AdminListen, r.doneCh)
 	}
 
 	go func() {
 		<-r.stopCh
-		log.Infof("Closing connection listener")
-		_ = listener.Close()
+		log.Infof("Closing connection listeners")
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
 	}()
 
 	var wg sync.WaitGroup
+	var acceptWg sync.WaitGroup
+	var counter atomic.Int32
+	for i := range listeners {
+		acceptWg.Add(1)
+		go func(name string, listener net.Listener) {
+			defer acceptWg.Done()
+			r.acceptLoop(name, listener, len(listeners) > 1, &counter, &wg)
+		}(listenerNames[i], listeners[i])
+	}
+	acceptWg.Wait()
 
-	i := 0
+	r.drain(&wg)
+	log.Infof("All connection closed")
+	close(r.doneCh)
+}
+
+func (r *MinecraftRouter) acceptLoop(listenerName string, listener net.Listener, showName bool, counter *atomic.Int32, wg *sync.WaitGroup) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Errorf("Error accepting connection: %v", err)
+			select {
+			case <-r.stopCh:
+			default:
+				log.Errorf("Error accepting connection on listener '%s': %v", listenerName, err)
+			}
 			break
 		}
-		i += 1
-		log.Infof("[%d] Accepted connection #%d from %s", i, i, conn.RemoteAddr())
+		i := int(counter.Add(1))
+		if showName {
+			log.Infof("[%d] Accepted connection #%d from %s on listener '%s'", i, i, rawRemoteAddr(conn), listenerName)
+		} else {
+			log.Infof("[%d] Accepted connection #%d from %s", i, i, rawRemoteAddr(conn))
+		}
 
 		wg.Add(1)
 		go func(id int, conn net.Conn) {
 			defer wg.Done()
-			handler := NewConnectionHandler(id, r.config, conn)
+			handler := NewConnectionHandler(id, r, listenerName, conn)
 			handler.handleConnection()
 		}(i, conn)
 	}
+}
+
+// drain waits for the existing connections to finish within the grace period, then force-closes the remaining ones
+func (r *MinecraftRouter) drain(wg *sync.WaitGroup) {
+	r.draining.Store(true)
+	allClosed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allClosed)
+	}()
+
+	gracePeriod := r.GetConfig().Shutdown.GracePeriod
+	if n := len(r.connections.List()); n > 0 {
+		log.Infof("Waiting for %d connections to finish, grace period %s", n, gracePeriod)
+	}
+	timer := time.NewTimer(gracePeriod)
+	defer timer.Stop()
+	select {
+	case <-allClosed:
+		return
+	case <-timer.C:
+	}
 
-	wg.Wait()
-	log.Infof("All connection closed")
+	conns := r.connections.List()
+	log.Warnf("Grace period ends, force closing %d connections", len(conns))
+	for _, info := range conns {
+		info.kick()
+	}
+	<-allClosed
 }
 
+// Stop stops accepting new connections, and starts draining the existing connections. Use Wait to wait for the end of it
 func (r *MinecraftRouter) Stop() {
-	r.stopCh <- struct{}{}
+	close(r.stopCh)
+}
+
+// Wait blocks until Run ends, i.e. all connections are closed after Stop
+func (r *MinecraftRouter) Wait() {
+	<-r.doneCh
 }
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+	"github.com/pires/go-proxyproto"
+)
+
+// statusServeMaxTimeWait is the maximum time SMCR waits for the client, when it's serving the status state by itself
+const statusServeMaxTimeWait = 10 * time.Second
+
+// statusPingProtocol is the protocol version SMCR uses in its own status pings. -1 is the convention for "just pinging"
+const statusPingProtocol = -1
+
+// fetchStatus performs a server list ping against the given target, and returns the status response json
+func fetchStatus(address string, handshake *protocol.HandshakePacket, proxyProtocol int, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", fmt.Errorf("failed to set deadline: %v", err)
+	}
+
+	if 1 <= proxyProtocol && proxyProtocol <= 2 {
+		// the connection is initiated by SMCR itself, so there's no client address to tell
+		proxyProtocolHeader := &proxyproto.Header{
+			Version:           byte(proxyProtocol),
+			Command:           proxyproto.LOCAL,
+			TransportProtocol: proxyproto.UNSPEC,
+		}
+		if _, err := proxyProtocolHeader.WriteTo(conn); err != nil {
+			return "", fmt.Errorf("failed to write proxy protocol header: %v", err)
+		}
+	}
+
+	rw := protocol.NewBufferReadWriter(conn)
+	if err := protocol.WritePacket(rw, handshake); err != nil {
+		return "", fmt.Errorf("failed to write handshake packet: %v", err)
+	}
+	if err := protocol.WritePacket(rw, &protocol.StatusRequestPacket{}); err != nil {
+		return "", fmt.Errorf("failed to write status request packet: %v", err)
+	}
+
+	var response protocol.StatusResponsePacket
+	if err := protocol.ReadExpectedPacket(rw, &response); err != nil {
+		return "", fmt.Errorf("failed to read status response packet: %v", err)
+	}
+	if !json.Valid([]byte(response.Response)) {
+		return "", fmt.Errorf("status response is not a valid json")
+	}
+	return response.Response, nil
+}
+
+// newStatusPingHandshake creates the handshake packet for SMCR's own status ping to the given address
+func newStatusPingHandshake(address string) (*protocol.HandshakePacket, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s: %v", portStr, err)
+	}
+	return &protocol.HandshakePacket{
+		Protocol:  statusPingProtocol,
+		Hostname:  host,
+		Port:      uint16(port),
+		NextState: protocol.HandshakeNextStateStatus,
+	}, nil
+}
+
+// serveStatus handles the status state for the client with the given status response json, including the ping-pong
+func (h *ConnectionHandler) serveStatus(rw protocol.BufReadWriter, responseJson string) {
+	_ = h.clientConn.SetDeadline(time.Now().Add(statusServeMaxTimeWait))
+
+	var request protocol.StatusRequestPacket
+	if err := protocol.ReadExpectedPacket(rw, &request); err != nil {
+		h.logger.Errorf("Failed to read status request packet from client: %v", err)
+		return
+	}
+	if err := protocol.WritePacket(rw, &protocol.StatusResponsePacket{Response: responseJson}); err != nil {
+		h.logger.Errorf("Failed to send status response packet to client: %v", err)
+		return
+	}
+	h.logger.Debugf("Sent status response %s", responseJson)
+
+	var ping protocol.PingRequestPacket
+	if err := protocol.ReadExpectedPacket(rw, &ping); err != nil {
+		// the client is allowed to close the connection without the ping
+		h.logger.Debugf("Failed to read ping request packet from client: %v", err)
+		return
+	}
+	if err := protocol.WritePacket(rw, &protocol.PongResponsePacket{Payload: ping.Payload}); err != nil {
+		h.logger.Errorf("Failed to send pong response packet to client: %v", err)
+		return
+	}
+}
+
+// proxyStatus serves the status state for the client with the cached status of the first available target
+func (h *ConnectionHandler) proxyStatus(rw protocol.BufReadWriter, route *config.Route, handshake *protocol.HandshakePacket, targets []dialTarget, onDialFailed func()) {
+	for _, dt := range targets {
+		address := dt.Address
+		target, err := h.resolveTarget(address)
+		if err != nil {
+			h.logger.Errorf("Failed to resolve target %s for route '%s': %v", address, route.Name, err)
+			continue
+		}
+
+		key := newStatusCacheKey(route, target, handshake.Protocol)
+		response, cached, err := h.router.statusCache.Get(key, route.StatusProxy.CacheTtl, func() (string, error) {
+			h.logger.Debugf("Fetching status from target %s", target)
+			return fetchStatus(target, handshake, route.ProxyProtocol, route.Timeout)
+		})
+		if err != nil {
+			h.logger.Errorf("Failed to fetch status from target %s (cached=%v): %v", target, cached, err)
+			continue
+		}
+
+		rewritten, err := route.StatusProxy.Rewrite(response)
+		if err != nil {
+			h.logger.Errorf("Failed to rewrite status from target %s: %v", target, err)
+			continue
+		}
+		h.logger.Infof("Responding with the status of target %s (cached=%v)", target, cached)
+		h.serveStatus(rw, rewritten)
+		return
+	}
+	onDialFailed()
+}
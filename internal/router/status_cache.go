@@ -0,0 +1,91 @@
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+)
+
+const statusCacheSweepInterval = time.Minute
+const statusCacheFailureTtl = time.Second    // failed fetches are cached for this long at most, so a down target is not hit by every ping
+const statusCacheMaxProtocol int32 = 1 << 12 // protocols outside [0, statusCacheMaxProtocol) share one cache entry
+
+// statusCacheKey identifies a cached status. The client hostname is not included, and client protocols are bucketed,
+// so clients cannot create entries without limit
+type statusCacheKey struct {
+	route    *config.Route
+	target   string
+	protocol int32
+}
+
+func newStatusCacheKey(route *config.Route, target string, protocol int32) statusCacheKey {
+	if protocol < 0 || protocol >= statusCacheMaxProtocol {
+		protocol = -1
+	}
+	return statusCacheKey{route: route, target: target, protocol: protocol}
+}
+
+type statusCacheEntry struct {
+	mutex    sync.Mutex
+	response string
+	err      error
+	expireAt time.Time
+}
+
+// statusCache caches status responses of targets, so concurrent and frequent server list pings
+// only cost one status ping to the target per TTL
+type statusCache struct {
+	mutex     sync.Mutex
+	entries   map[statusCacheKey]*statusCacheEntry
+	lastSweep time.Time
+}
+
+func newStatusCache() *statusCache {
+	return &statusCache{
+		entries:   make(map[statusCacheKey]*statusCacheEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the cached response for the given key if it's not expired, otherwise fetch and cache a new one.
+// Concurrent calls with the same key wait for the same fetch. Failures are cached too, for a shorter time
+func (c *statusCache) Get(key statusCacheKey, ttl time.Duration, fetch func() (string, error)) (response string, cached bool, err error) {
+	now := time.Now()
+	c.mutex.Lock()
+	if now.Sub(c.lastSweep) >= statusCacheSweepInterval {
+		c.lastSweep = now
+		for k, e := range c.entries {
+			if e.mutex.TryLock() {
+				if now.After(e.expireAt) {
+					delete(c.entries, k)
+				}
+				e.mutex.Unlock()
+			}
+		}
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &statusCacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if time.Now().Before(entry.expireAt) {
+		return entry.response, true, entry.err
+	}
+	response, err = fetch()
+	entry.response, entry.err = response, err
+	if err != nil {
+		failureTtl := statusCacheFailureTtl
+		if ttl < failureTtl {
+			failureTtl = ttl
+		}
+		entry.expireAt = time.Now().Add(failureTtl)
+		return "", false, err
+	}
+	entry.expireAt = time.Now().Add(ttl)
+	return response, false, nil
+}
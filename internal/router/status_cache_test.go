@@ -0,0 +1,107 @@
+package router
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Fallen-Breath/smcr/internal/config"
+)
+
+func TestStatusCacheTtl(t *testing.T) {
+	c := newStatusCache()
+	key := newStatusCacheKey(&config.Route{}, "10.0.0.1:25565", 767)
+	fetches := 0
+	fetch := func() (string, error) {
+		fetches++
+		return "status", nil
+	}
+
+	if response, cached, err := c.Get(key, time.Minute, fetch); response != "status" || cached || err != nil {
+		t.Errorf("First get should fetch, found %q, %v, %v", response, cached, err)
+	}
+	if response, cached, err := c.Get(key, time.Minute, fetch); response != "status" || !cached || err != nil {
+		t.Errorf("Second get should hit the cache, found %q, %v, %v", response, cached, err)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected 1 fetch within the TTL, found %d", fetches)
+	}
+
+	// other protocols have their own entry
+	if _, cached, _ := c.Get(newStatusCacheKey(key.route, key.target, 763), time.Minute, fetch); cached {
+		t.Errorf("Different protocols should not share the cache entry")
+	}
+
+	c.entries[key].expireAt = time.Now().Add(-time.Millisecond)
+	if _, cached, _ := c.Get(key, time.Minute, fetch); cached || fetches != 3 {
+		t.Errorf("Expired entry should be fetched again, found cached=%v with %d fetches", cached, fetches)
+	}
+}
+
+func TestStatusCacheFailure(t *testing.T) {
+	c := newStatusCache()
+	key := newStatusCacheKey(&config.Route{}, "10.0.0.1:25565", 767)
+	down := errors.New("connection refused")
+	fetches := 0
+	fetch := func() (string, error) {
+		fetches++
+		return "", down
+	}
+
+	start := time.Now()
+	if _, cached, err := c.Get(key, time.Minute, fetch); cached || err != down {
+		t.Errorf("First get should fetch and fail, found %v, %v", cached, err)
+	}
+	if _, cached, err := c.Get(key, time.Minute, fetch); !cached || err != down || fetches != 1 {
+		t.Errorf("Failure should be cached, found %v, %v with %d fetches", cached, err, fetches)
+	}
+	if expireAt := c.entries[key].expireAt; expireAt.After(time.Now().Add(statusCacheFailureTtl)) || expireAt.Before(start) {
+		t.Errorf("Failure should be cached for at most %s, expires in %s", statusCacheFailureTtl, time.Until(expireAt))
+	}
+
+	// TTLs shorter than the failure TTL are kept
+	c.Get(newStatusCacheKey(key.route, key.target, 763), 10*time.Millisecond, fetch)
+	time.Sleep(20 * time.Millisecond)
+	if _, cached, _ := c.Get(newStatusCacheKey(key.route, key.target, 763), 10*time.Millisecond, fetch); cached {
+		t.Errorf("Failure should expire with the shorter TTL")
+	}
+}
+
+func TestStatusCacheConcurrentFetch(t *testing.T) {
+	c := newStatusCache()
+	key := newStatusCacheKey(&config.Route{}, "10.0.0.1:25565", 767)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	fetch := func() (string, error) {
+		fetches.Add(1)
+		<-release
+		return "status", nil
+	}
+
+	const callers = 8
+	var wg sync.WaitGroup
+	responses := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, _, _ := c.Get(key, time.Minute, fetch)
+			responses <- response
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(responses)
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected 1 fetch for concurrent callers, found %d", n)
+	}
+	for response := range responses {
+		if response != "status" {
+			t.Errorf("Expected the fetched response, found %q", response)
+		}
+	}
+}
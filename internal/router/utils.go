@@ -1,37 +1,68 @@
 package router
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
-func checkIpWhitelist(clientAddr net.Addr, ipWhitelist []string) bool {
-	host, _, err := net.SplitHostPort(clientAddr.String())
-	if err != nil {
-		return false
+	"github.com/Fallen-Breath/smcr/internal/config"
+	"github.com/Fallen-Breath/smcr/internal/dns"
+	"github.com/Fallen-Breath/smcr/internal/protocol"
+	"github.com/pires/go-proxyproto"
+	log "github.com/sirupsen/logrus"
+)
+
+// tcpRemoteIp returns the ip of the real tcp remote address of the connection, regardless of the proxy protocol header.
+// It might return nil
+func tcpRemoteIp(conn net.Conn) net.IP {
+	addr := conn.RemoteAddr()
+	if ppConn, ok := conn.(*proxyproto.Conn); ok {
+		addr = ppConn.Raw().RemoteAddr()
 	}
+	return addrIp(addr)
+}
 
-	clientIP := net.ParseIP(host)
-	if clientIP == nil {
-		return false
+// addrIp returns the ip of the address. It might return nil
+func addrIp(addr net.Addr) net.IP {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
 	}
+	return net.ParseIP(host)
+}
 
-	for _, entry := range ipWhitelist {
-		ip := net.ParseIP(entry)
-		if ip != nil {
-			if ip.Equal(clientIP) {
-				return true
-			}
-			continue
-		}
+// resolveTarget resolves the port of the target address with SRV lookup, if the port is absent
+func resolveTarget(target string, srvLookupTimeout time.Duration, logger *log.Entry) (string, error) {
+	if !strings.Contains(target, ":") { // no port, might be an SRV record
+		t := time.Now()
+		resolved, err := dns.ResolveSrv(target, srvLookupTimeout)
+		logger.Debugf("SRV Resolution for %s cost %dms", target, time.Now().Sub(t).Milliseconds())
 
-		ips, err := net.LookupIP(entry)
-		if err != nil {
-			continue
-		}
-		for _, domainIP := range ips {
-			if domainIP.Equal(clientIP) {
-				return true
-			}
+		if err == nil {
+			return resolved, nil
+		} else {
+			logger.Debugf("Resolved SRV record for %s failed: %v", target, err)
 		}
+		return fmt.Sprintf("%s:25565", target), nil
 	}
+	return target, nil
+}
 
-	return false
+// nextStateName returns the readable next state in the handshake, for logging
+func nextStateName(query *config.RouteQuery) string {
+	if query.IsLegacy {
+		return "legacy_ping"
+	}
+	switch query.NextState {
+	case protocol.HandshakeNextStateStatus:
+		return "status"
+	case protocol.HandshakeNextStateLogin:
+		return "login"
+	case protocol.HandshakeNextStateTransfer:
+		return "transfer"
+	default:
+		return strconv.Itoa(int(query.NextState))
+	}
 }